@@ -87,9 +87,45 @@ func main() {
 	}
 
 	fmt.Println("\n✅ All bots are running!")
+	fmt.Println("🔄 Edit the config file or send SIGHUP to reload it")
 	fmt.Println("👋 Press Ctrl+C to stop")
 
-	// Wait for shutdown signal
-	<-ctx.Done()
-	fmt.Println("\n👋 Shutting down...")
+	// Reload the config on SIGHUP or when the file changes
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	changes := config.Watch(ctx, *configPath)
+
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Println("\n👋 Shutting down...")
+
+			// A second signal stops waiting for running prompts
+			force, stopForce := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stopForce()
+			manager.Shutdown(force)
+			fmt.Println("👋 Bye")
+			return
+		case <-hangup:
+		case <-changes:
+		}
+		reloadConfig(ctx, manager, *configPath)
+	}
+}
+
+// reloadConfig loads the config file again and applies it to the running bots.
+// An invalid config is reported and the current one is kept.
+func reloadConfig(ctx context.Context, manager *bot.Manager, path string) {
+	fmt.Printf("📄 Reloading config from: %s\n", path)
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		fmt.Printf("❌ Failed to reload config, keeping the current one: %v\n", err)
+		return
+	}
+
+	if err := manager.Reload(ctx, cfg); err != nil {
+		fmt.Printf("❌ Failed to apply parts of the config: %v\n", err)
+		return
+	}
+	fmt.Printf("✅ Config reloaded (%d workspace(s))\n", len(cfg.Workspaces))
 }
@@ -0,0 +1,162 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+	"telecode/internal/session"
+)
+
+const (
+	// minAttachLines is the minimum size of a code block or diff that is
+	// sent as a document even though it is below the length threshold
+	minAttachLines = 20
+
+	// previewLines is the number of lines shown inline next to an attachment
+	previewLines = 10
+)
+
+// codeExtensions maps fenced code block language hints to file extensions
+var codeExtensions = map[string]string{
+	"go":         "go",
+	"python":     "py",
+	"py":         "py",
+	"javascript": "js",
+	"js":         "js",
+	"typescript": "ts",
+	"ts":         "ts",
+	"json":       "json",
+	"yaml":       "yaml",
+	"yml":        "yaml",
+	"bash":       "sh",
+	"sh":         "sh",
+	"shell":      "sh",
+	"diff":       "diff",
+	"patch":      "diff",
+	"rust":       "rs",
+	"java":       "java",
+	"c":          "c",
+	"cpp":        "cpp",
+	"sql":        "sql",
+	"html":       "html",
+	"css":        "css",
+}
+
+// sendResponse sends an agent response, as a document if it is longer than
+// threshold runes or is mostly a code block or diff, otherwise as text messages
+func sendResponse(ctx context.Context, bot *telego.Bot, key session.Key, text string, threshold int) error {
+	trimmedText := strings.TrimSpace(text)
+
+	name, content, ok := attachmentFor(trimmedText, threshold)
+	if !ok {
+		return sendChunks(ctx, bot, key, text)
+	}
+	return sendDocument(ctx, bot, key, name, content)
+}
+
+// attachmentFor decides whether text should be attached and returns the file name and content
+func attachmentFor(text string, threshold int) (name, content string, ok bool) {
+	if lang, code, ok := singleCodeBlock(text); ok && countLines(code) >= minAttachLines {
+		ext := codeExtensions[strings.ToLower(lang)]
+		if ext == "" {
+			ext = "txt"
+		}
+		return "response." + ext, code, true
+	}
+
+	if isUnifiedDiff(text) && countLines(text) >= minAttachLines {
+		return "response.diff", text, true
+	}
+
+	if threshold > 0 && len([]rune(text)) > threshold {
+		if strings.Contains(text, "```") || strings.Contains(text, "\n#") {
+			return "response.md", text, true
+		}
+		return "response.txt", text, true
+	}
+
+	return "", "", false
+}
+
+// sendDocument uploads content as a file with a short summary and the first lines inline
+func sendDocument(ctx context.Context, bot *telego.Bot, key session.Key, name, content string) error {
+	lines := strings.Split(content, "\n")
+	preview := strings.Join(lines[:min(previewLines, len(lines))], "\n")
+	if runes := []rune(preview); len(runes) > 1000 {
+		preview = string(runes[:1000])
+	}
+
+	summary := fmt.Sprintf("📎 %s · %d lines · %.1f KB", name, len(lines), float64(len(content))/1024)
+	if _, err := bot.SendMessage(ctx, chatMessage(
+		key,
+		summary+"\n\n"+preview+"\n…",
+	)); err != nil {
+		return err
+	}
+	return sendFile(ctx, bot, key, name, content)
+}
+
+// sendFile uploads content as a file to the chat of key
+func sendFile(ctx context.Context, bot *telego.Bot, key session.Key, name, content string) error {
+	_, err := bot.SendDocument(ctx, tu.Document(
+		tu.ID(key.ChatID),
+		tu.FileFromBytes([]byte(content), name),
+	).WithMessageThreadID(key.ThreadID))
+	return err
+}
+
+// singleCodeBlock reports whether text is mostly one fenced code block,
+// returning its language hint and content
+func singleCodeBlock(text string) (lang, code string, ok bool) {
+	start := strings.Index(text, "```")
+	if start < 0 {
+		return "", "", false
+	}
+	headerEnd := strings.Index(text[start:], "\n")
+	if headerEnd < 0 {
+		return "", "", false
+	}
+	headerEnd += start
+	end := strings.LastIndex(text, "```")
+	if end <= headerEnd {
+		return "", "", false
+	}
+
+	lang = strings.TrimSpace(text[start+3 : headerEnd])
+	code = text[headerEnd+1 : end]
+
+	// "Mostly": the block covers at least 80% of the text and there is only one
+	if strings.Count(text, "```") != 2 || len(code)*5 < len(text)*4 {
+		return "", "", false
+	}
+	return lang, code, true
+}
+
+// isUnifiedDiff reports whether text looks like a unified diff
+func isUnifiedDiff(text string) bool {
+	if !strings.Contains(text, "\n+++ ") || !strings.Contains(text, "\n@@ ") {
+		return false
+	}
+	if !strings.HasPrefix(text, "--- ") && !strings.HasPrefix(text, "diff ") && !strings.Contains(text, "\n--- ") {
+		return false
+	}
+
+	// Most lines must be diff lines
+	lines := strings.Split(text, "\n")
+	diffLines := 0
+	for _, line := range lines {
+		if line == "" || strings.HasPrefix(line, "diff ") || strings.HasPrefix(line, "index ") ||
+			strings.HasPrefix(line, "@@") || strings.ContainsAny(line[:1], " +-\\") {
+			diffLines++
+		}
+	}
+	return diffLines*5 >= len(lines)*4
+}
+
+// countLines returns the number of lines in s
+func countLines(s string) int {
+	return strings.Count(strings.TrimRight(s, "\n"), "\n") + 1
+}
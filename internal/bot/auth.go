@@ -0,0 +1,116 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mymmrac/telego"
+	"telecode/internal/config"
+	"telecode/internal/session"
+)
+
+// role is the access level of a user, ordered from no access to full access
+type role int
+
+const (
+	roleNone role = iota
+	roleReadOnly
+	roleOperator
+	roleAdmin
+)
+
+// commandRoles is the minimum role needed for a command; prompts and
+// commands not listed here are open to every role
+var commandRoles = map[string]role{
+	"/new":     roleOperator,
+	"/switch":  roleOperator,
+	"/resume":  roleOperator,
+	"/cancel":  roleOperator,
+	"/queue":   roleOperator,
+	"/model":   roleOperator,
+	"/merge":   roleOperator,
+	"/discard": roleOperator,
+	"/undo":    roleOperator,
+	"/restore": roleOperator,
+	"/commit":  roleOperator,
+	"/cli":     roleAdmin,
+}
+
+// parseRole converts a config role name to a role
+func parseRole(name string) role {
+	switch name {
+	case "admin":
+		return roleAdmin
+	case "operator":
+		return roleOperator
+	case "read_only":
+		return roleReadOnly
+	default:
+		return roleNone
+	}
+}
+
+// String returns the config name of the role
+func (r role) String() string {
+	switch r {
+	case roleAdmin:
+		return "admin"
+	case roleOperator:
+		return "operator"
+	case roleReadOnly:
+		return "read_only"
+	default:
+		return "none"
+	}
+}
+
+// access decides which users may use a workspace bot and with which role
+type access struct {
+	chats       map[int64]bool
+	users       map[int64]role
+	anyChat     map[int64]bool // Users whose role applies outside allowed chats
+	defaultRole role
+}
+
+// newAccess builds the access rules of a workspace
+func newAccess(cfg config.WorkspaceConfig) access {
+	a := access{
+		chats:       make(map[int64]bool),
+		users:       make(map[int64]role),
+		anyChat:     make(map[int64]bool),
+		defaultRole: parseRole(cfg.DefaultRole),
+	}
+	for _, chatID := range cfg.AllowedChats {
+		a.chats[chatID] = true
+	}
+	for _, user := range cfg.AllowedUsers {
+		a.users[user.ID] = parseRole(user.Role)
+		a.anyChat[user.ID] = user.AnyChat
+	}
+	return a
+}
+
+// role returns the role of a user in a chat. Only allowed chats are served,
+// unless the user is listed with AnyChat; there listed users have their role
+// and other users the default role.
+func (a access) role(chatID, userID int64) role {
+	r, listed := a.users[userID]
+	listed = listed && userID != 0
+	switch {
+	case listed && (a.chats[chatID] || a.anyChat[userID]):
+		return r
+	case a.chats[chatID]:
+		return a.defaultRole
+	default:
+		return roleNone
+	}
+}
+
+// denyCommand tells the user that a command needs a higher role
+func (m *Manager) denyCommand(ctx context.Context, ws *WorkspaceBot, key session.Key, cmd string, need role) error {
+	_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+		key,
+		fmt.Sprintf("🔒 %s requires the <code>%s</code> role", cmd, need),
+	).WithParseMode(telego.ModeHTML))
+	return err
+}
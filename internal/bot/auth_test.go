@@ -0,0 +1,48 @@
+package bot
+
+import (
+	"testing"
+
+	"telecode/internal/config"
+)
+
+func TestAccessRole(t *testing.T) {
+	const (
+		allowedChat = int64(-1001)
+		otherChat   = int64(-1002)
+		listedUser  = int64(10)
+		roamingUser = int64(11)
+		otherUser   = int64(12)
+	)
+	a := newAccess(config.WorkspaceConfig{
+		AllowedChats: []int64{allowedChat},
+		AllowedUsers: []config.UserRule{
+			{ID: listedUser, Role: "operator"},
+			{ID: roamingUser, Role: "admin", AnyChat: true},
+		},
+		DefaultRole: "read_only",
+	})
+
+	tests := []struct {
+		name   string
+		chatID int64
+		userID int64
+		want   role
+	}{
+		{"listed user in allowed chat", allowedChat, listedUser, roleOperator},
+		{"listed user in other chat", otherChat, listedUser, roleNone},
+		{"listed user in private chat", listedUser, listedUser, roleNone},
+		{"other user in allowed chat", allowedChat, otherUser, roleReadOnly},
+		{"other user in other chat", otherChat, otherUser, roleNone},
+		{"any_chat user in allowed chat", allowedChat, roamingUser, roleAdmin},
+		{"any_chat user in other chat", otherChat, roamingUser, roleAdmin},
+		{"anonymous sender in allowed chat", allowedChat, 0, roleReadOnly},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := a.role(tt.chatID, tt.userID); got != tt.want {
+				t.Errorf("role(%d, %d) = %s, want %s", tt.chatID, tt.userID, got, tt.want)
+			}
+		})
+	}
+}
@@ -3,121 +3,260 @@ package bot
 import (
 	"fmt"
 	"os/exec"
+	"slices"
+	"sort"
 	"sync"
 
+	"telecode/internal/config"
 	"telecode/internal/executor"
 	"telecode/internal/session"
 )
 
-// ChatSettings stores per-chat configuration
-type ChatSettings struct {
-	CLI string `json:"cli"`
-}
-
 // Bot handles the core logic of the Telegram bot
 type Bot struct {
-	sessionMgr   *session.Manager
-	chatSettings map[int64]ChatSettings
-	settingsMu   sync.RWMutex
-	allowedChats map[int64]bool
-	executors    map[string]executor.Executor
-	defaultCLI   string
-	model        string
+	sessionMgr *session.Manager
+	access     access
+	executors  map[string]executor.Executor
+	defaultCLI string
+	model      string              // Default model of OpenCode
+	defaults   map[string]string   // Default model per CLI
+	models     map[string][]string // Models allowed per CLI
+	mu         sync.RWMutex        // Guards the settings changed by Reconfigure and SetExecutors
 }
 
 // NewBot creates a new bot instance
-func NewBot(allowedChats map[int64]bool, defaultCLI string, model string) *Bot {
+func NewBot(sessionMgr *session.Manager, access access, executors map[string]executor.Executor, defaultCLI string, model string, defaults map[string]string, models map[string][]string) *Bot {
 	return &Bot{
-		sessionMgr:   session.NewManager(),
-		chatSettings: make(map[int64]ChatSettings),
-		allowedChats: allowedChats,
-		executors: map[string]executor.Executor{
-			"claude":   &executor.ClaudeExecutor{},
-			"opencode": &executor.OpenCodeExecutor{},
-		},
+		sessionMgr: sessionMgr,
+		access:     access,
+		executors:  executors,
 		defaultCLI: defaultCLI,
 		model:      model,
+		defaults:   defaults,
+		models:     models,
+	}
+}
+
+// newExecutors returns the built-in executors and those of the custom CLIs
+func newExecutors(custom []config.CustomCLIConfig) (map[string]executor.Executor, error) {
+	executors := map[string]executor.Executor{
+		"claude":   &executor.ClaudeExecutor{},
+		"opencode": &executor.OpenCodeExecutor{},
+		"aider":    &executor.AiderExecutor{},
+		"codex":    &executor.CodexExecutor{},
+		"gemini":   &executor.GeminiExecutor{},
+	}
+	for _, cli := range custom {
+		if _, ok := executors[cli.Name]; ok {
+			return nil, fmt.Errorf("custom CLI %s: name is taken by a built-in CLI", cli.Name)
+		}
+		e, err := executor.NewCustomExecutor(cli)
+		if err != nil {
+			return nil, err
+		}
+		executors[cli.Name] = e
+	}
+	return executors, nil
+}
+
+// SetExecutors replaces the available CLIs after the custom CLIs changed
+func (b *Bot) SetExecutors(executors map[string]executor.Executor) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.executors = executors
+}
+
+// CLIs returns the names of the available CLIs, sorted
+func (b *Bot) CLIs() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	names := make([]string, 0, len(b.executors))
+	for name := range b.executors {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+	return names
 }
 
-// IsAllowed checks if the chat_id is in the allowlist
-func (b *Bot) IsAllowed(chatID int64) bool {
-	return b.allowedChats[chatID]
+// Reconfigure replaces the settings loaded from the config file
+func (b *Bot) Reconfigure(access access, defaultCLI string, model string, defaults map[string]string, models map[string][]string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.access = access
+	b.defaultCLI = defaultCLI
+	b.model = model
+	b.defaults = defaults
+	b.models = models
+}
+
+// Role returns the role of a user in a chat; roleNone means the message is ignored
+func (b *Bot) Role(chatID, userID int64) role {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.access.role(chatID, userID)
 }
 
 // GetCLI returns the CLI setting for a chat
-func (b *Bot) GetCLI(chatID int64) string {
-	b.settingsMu.RLock()
-	defer b.settingsMu.RUnlock()
-	if cli := b.chatSettings[chatID].CLI; cli != "" {
+func (b *Bot) GetCLI(key session.Key) string {
+	if cli := b.sessionMgr.GetSettings(key).CLI; cli != "" {
 		return cli
 	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
 	return b.defaultCLI
 }
 
 // SetCLI sets the CLI for a chat
-func (b *Bot) SetCLI(chatID int64, cli string) error {
-	// Check if CLI exists
-	if _, err := exec.LookPath(cli); err != nil {
-		return fmt.Errorf("CLI '%s' is not installed", cli)
+func (b *Bot) SetCLI(key session.Key, cli string) error {
+	e := b.GetExecutor(cli)
+	if e == nil {
+		return fmt.Errorf("unsupported CLI: %s", cli)
+	}
+
+	// Check if the CLI's program exists
+	if cmd := e.BuildCommand("", "", nil, "", false); len(cmd) > 0 {
+		if _, err := exec.LookPath(cmd[0]); err != nil {
+			return fmt.Errorf("CLI '%s' is not installed", cli)
+		}
 	}
 
-	b.settingsMu.Lock()
-	defer b.settingsMu.Unlock()
-	settings := b.chatSettings[chatID]
+	// Models are specific to a CLI
+	settings := b.sessionMgr.GetSettings(key)
 	settings.CLI = cli
-	b.chatSettings[chatID] = settings
+	settings.Model = ""
+	b.sessionMgr.SetSettings(key, settings)
 
-	// Reset session when CLI changes
-	b.sessionMgr.Delete(chatID)
+	// Start a fresh session when CLI changes (previous sessions stay listed)
+	b.sessionMgr.Reset(key)
 
 	return nil
 }
 
-// GetSessionID returns the session ID for a chat
-func (b *Bot) GetSessionID(chatID int64) string {
-	return b.sessionMgr.Get(chatID)
+// GetSessionID returns the active session ID for a chat
+func (b *Bot) GetSessionID(key session.Key) string {
+	return b.sessionMgr.Get(key)
+}
+
+// NewSession starts a new session, named automatically if name is empty
+func (b *Bot) NewSession(key session.Key, name string) (session.Session, error) {
+	return b.sessionMgr.New(key, name, b.GetCLI(key))
 }
 
-// NewSession starts a new session
-func (b *Bot) NewSession(chatID int64) {
-	b.sessionMgr.Delete(chatID)
+// ActiveSession returns the active session of a chat, if it has one
+func (b *Bot) ActiveSession(key session.Key) (session.Session, bool) {
+	return b.sessionMgr.Active(key)
+}
+
+// ResetSession deactivates the current session so the next prompt starts a new one
+func (b *Bot) ResetSession(key session.Key) {
+	b.sessionMgr.Reset(key)
+}
+
+// ListSessions returns the sessions of a chat and the name of the active one
+func (b *Bot) ListSessions(key session.Key) ([]session.Session, string) {
+	return b.sessionMgr.List(key)
+}
+
+// SwitchSession makes a named session active, switching to the CLI it was created with
+func (b *Bot) SwitchSession(key session.Key, name string) (session.Session, error) {
+	s, err := b.sessionMgr.Switch(key, name)
+	if err != nil {
+		return s, err
+	}
+	if s.CLI != "" && s.CLI != b.GetCLI(key) {
+		settings := b.sessionMgr.GetSettings(key)
+		settings.CLI = s.CLI
+		settings.Model = ""
+		b.sessionMgr.SetSettings(key, settings)
+	}
+	return s, nil
+}
+
+// ResumeSession attaches an existing session ID of the current CLI
+func (b *Bot) ResumeSession(key session.Key, sessionID string) session.Session {
+	return b.sessionMgr.Attach(key, sessionID, b.GetCLI(key))
 }
 
 // UpdateSessionFromOutput extracts and saves session ID from output
-func (b *Bot) UpdateSessionFromOutput(chatID int64, cli, output string) {
-	exec := b.executors[cli]
+func (b *Bot) UpdateSessionFromOutput(key session.Key, cli, output, prompt string) {
+	exec := b.GetExecutor(cli)
 	if exec == nil {
 		return
 	}
 
-	if sessionID := exec.ParseSessionID(output); sessionID != "" {
-		b.sessionMgr.Set(chatID, sessionID)
-	}
+	b.sessionMgr.Update(key, cli, exec.ParseSessionID(output), prompt)
 }
 
 // GetExecutor returns the Executor for a CLI name
 func (b *Bot) GetExecutor(cli string) executor.Executor {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
 	return b.executors[cli]
 }
 
-// BuildCommand builds the CLI command
-func (b *Bot) BuildCommand(chatID int64, prompt, imagePath string) []string {
-	cli := b.GetCLI(chatID)
-	sessionID := b.GetSessionID(chatID)
+// BuildCommand builds the CLI command; readOnly restricts the agent to planning
+func (b *Bot) BuildCommand(key session.Key, prompt string, files []string, readOnly bool) []string {
+	cli := b.GetCLI(key)
+	sessionID := b.GetSessionID(key)
 
-	exec := b.executors[cli]
+	exec := b.GetExecutor(cli)
 	if exec == nil {
 		return nil
 	}
 
-	return exec.BuildCommand(prompt, sessionID, imagePath, b.model)
+	// Record the assigned ID so the next prompt continues the session
+	if assigner, ok := exec.(executor.SessionAssigner); ok && sessionID == "" {
+		sessionID = assigner.NewSessionID()
+		b.sessionMgr.Update(key, cli, sessionID, prompt)
+	}
+
+	return exec.BuildCommand(prompt, sessionID, files, b.GetModel(key), readOnly)
+}
+
+// Models returns the models the current CLI of a chat may be switched to
+func (b *Bot) Models(key session.Key) []string {
+	cli := b.GetCLI(key)
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.models[cli]
+}
+
+// GetModel returns the model for a chat: its own choice while the config
+// still allows it, otherwise the default model of its CLI ("" for the CLI's
+// own default). The workspace model is in OpenCode's provider/model format,
+// so it only applies to OpenCode.
+func (b *Bot) GetModel(key session.Key) string {
+	if model := b.sessionMgr.GetSettings(key).Model; model != "" && slices.Contains(b.Models(key), model) {
+		return model
+	}
+	cli := b.GetCLI(key)
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if model := b.defaults[cli]; model != "" {
+		return model
+	}
+	if cli == "opencode" {
+		return b.model
+	}
+	return ""
+}
+
+// SetModel sets the model for a chat; "" goes back to the default model of its CLI
+func (b *Bot) SetModel(key session.Key, model string) error {
+	if model != "" && !slices.Contains(b.Models(key), model) {
+		return fmt.Errorf("model '%s' is not allowed for %s", model, b.GetCLI(key))
+	}
+
+	settings := b.sessionMgr.GetSettings(key)
+	settings.Model = model
+	b.sessionMgr.SetSettings(key, settings)
+	return nil
 }
 
 // GetStats returns statistics for current CLI
-func (b *Bot) GetStats(chatID int64) (string, error) {
-	cli := b.GetCLI(chatID)
-	exec := b.executors[cli]
+func (b *Bot) GetStats(key session.Key) (string, error) {
+	cli := b.GetCLI(key)
+	exec := b.GetExecutor(cli)
 	if exec == nil {
 		return "", fmt.Errorf("unsupported CLI: %s", cli)
 	}
@@ -125,10 +264,14 @@ func (b *Bot) GetStats(chatID int64) (string, error) {
 }
 
 // GetStatus returns the current status
-func (b *Bot) GetStatus(chatID int64) (cli, sessionID string) {
-	cli = b.GetCLI(chatID)
-	sessionID = b.GetSessionID(chatID)
+func (b *Bot) GetStatus(key session.Key) (cli, sessionName, sessionID string) {
+	cli = b.GetCLI(key)
+	active, _ := b.sessionMgr.Active(key)
+	sessionName, sessionID = active.Name, active.ID
 
+	if sessionName == "" {
+		sessionName = "new"
+	}
 	if sessionID == "" {
 		sessionID = "none"
 	}
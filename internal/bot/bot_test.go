@@ -0,0 +1,57 @@
+package bot
+
+import (
+	"slices"
+	"testing"
+
+	"telecode/internal/executor"
+	"telecode/internal/session"
+)
+
+func TestBuildCommandModel(t *testing.T) {
+	tests := []struct {
+		name     string
+		cli      string
+		model    string // Workspace model
+		defaults map[string]string
+		chosen   string // Chosen with /model
+		want     string // Expected --model value, "" for none
+	}{
+		{"claude without a model", "claude", "", nil, "", ""},
+		{"claude ignores the OpenCode model", "claude", "anthropic/opus-4.6", nil, "", ""},
+		{"claude default model", "claude", "anthropic/opus-4.6", map[string]string{"claude": "sonnet"}, "", "sonnet"},
+		{"claude chosen model", "claude", "", map[string]string{"claude": "sonnet"}, "opus", "opus"},
+		{"opencode workspace model", "opencode", "openai/gpt-5", nil, "", "openai/gpt-5"},
+		{"opencode default model", "opencode", "openai/gpt-5", map[string]string{"opencode": "anthropic/sonnet-4.5"}, "", "anthropic/sonnet-4.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sessions, err := session.NewManager(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			executors := map[string]executor.Executor{
+				"claude":   &executor.ClaudeExecutor{},
+				"opencode": &executor.OpenCodeExecutor{},
+			}
+			models := map[string][]string{"claude": {"sonnet", "opus"}}
+			b := NewBot(sessions, access{}, executors, tt.cli, tt.model, tt.defaults, models)
+
+			key := session.Key{ChatID: 1}
+			if tt.chosen != "" {
+				if err := b.SetModel(key, tt.chosen); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			cmd := b.BuildCommand(key, "hi", nil, false)
+			got := ""
+			if i := slices.Index(cmd, "--model"); i >= 0 {
+				got = cmd[i+1]
+			}
+			if got != tt.want {
+				t.Errorf("--model = %q, want %q (command %q)", got, tt.want, cmd)
+			}
+		})
+	}
+}
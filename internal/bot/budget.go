@@ -0,0 +1,164 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"telecode/internal/config"
+	"telecode/internal/session"
+	"telecode/internal/usage"
+)
+
+// budgetLimit is a configured limit with the usage counted against it
+type budgetLimit struct {
+	name  string // e.g. "workspace daily cost"
+	cost  bool   // Limit in US dollars, otherwise in tokens
+	used  float64
+	limit float64
+}
+
+// exceeded reports whether the limit has been reached
+func (l budgetLimit) exceeded() bool {
+	return l.used >= l.limit
+}
+
+// String formats the limit with its usage, e.g. "workspace daily cost: $4.20 of $5.00"
+func (l budgetLimit) String() string {
+	if l.cost {
+		return fmt.Sprintf("%s: %s of %s", l.name, formatCost(l.used), formatCost(l.limit))
+	}
+	return fmt.Sprintf("%s: %s of %s", l.name, formatTokens(int64(l.used)), formatTokens(int64(l.limit)))
+}
+
+// budgetLimits returns the configured limits of a workspace and one of its users
+func (m *Manager) budgetLimits(ws *WorkspaceBot, userID int64, now time.Time) []budgetLimit {
+	cfg := ws.Config()
+	inWorkspace := func(e usage.Entry) bool { return e.Workspace == cfg.Name }
+	byUser := func(e usage.Entry) bool { return inWorkspace(e) && e.UserID == userID }
+
+	var limits []budgetLimit
+	add := func(scope string, budget config.BudgetLimits, filter func(usage.Entry) bool) {
+		day := m.ledger.Sum(usage.StartOfDay(now), filter)
+		month := m.ledger.Sum(usage.StartOfMonth(now), filter)
+		for _, l := range []budgetLimit{
+			{scope + " daily cost", true, day.CostUSD, budget.DailyCost},
+			{scope + " monthly cost", true, month.CostUSD, budget.MonthlyCost},
+			{scope + " daily tokens", false, float64(day.Tokens()), float64(budget.DailyTokens)},
+			{scope + " monthly tokens", false, float64(month.Tokens()), float64(budget.MonthlyTokens)},
+		} {
+			if l.limit > 0 {
+				limits = append(limits, l)
+			}
+		}
+	}
+	add("workspace", cfg.Budget.Workspace, inWorkspace)
+	add("user", cfg.Budget.User, byUser)
+	return limits
+}
+
+// budgetExceeded returns the exceeded limits of a workspace and user, or
+// nil if there are none or the budget was lifted for today
+func (m *Manager) budgetExceeded(ws *WorkspaceBot, userID int64) []budgetLimit {
+	now := time.Now()
+	if m.ledger.Overridden(ws.Config().Name, now) {
+		return nil
+	}
+	var exceeded []budgetLimit
+	for _, l := range m.budgetLimits(ws, userID, now) {
+		if l.exceeded() {
+			exceeded = append(exceeded, l)
+		}
+	}
+	return exceeded
+}
+
+// budgetWarnings returns a warning for every threshold in warnAt a limit
+// crossed between before and after
+func budgetWarnings(before, after []budgetLimit, warnAt []float64) []string {
+	var warnings []string
+	for i, l := range after {
+		if i >= len(before) || before[i].name != l.name {
+			continue
+		}
+		// Warn once, for the highest threshold crossed
+		crossed := 0.0
+		for _, fraction := range warnAt {
+			threshold := fraction * l.limit
+			if before[i].used < threshold && l.used >= threshold {
+				crossed = max(crossed, fraction)
+			}
+		}
+		switch {
+		case before[i].used < l.limit && l.exceeded():
+			warnings = append(warnings, fmt.Sprintf("🚫 Budget reached, %s", l))
+		case crossed > 0:
+			warnings = append(warnings, fmt.Sprintf("⚠️ %.0f%% of budget used, %s", crossed*100, l))
+		}
+	}
+	return warnings
+}
+
+// sendBudgetWarnings sends the warnings of a run to its chat
+func (m *Manager) sendBudgetWarnings(ctx context.Context, ws *WorkspaceBot, key session.Key, warnings []string) {
+	if len(warnings) == 0 {
+		return
+	}
+	if _, err := ws.TgBot.SendMessage(ctx, chatMessage(
+		key,
+		strings.Join(warnings, "\n"),
+	)); err != nil {
+		fmt.Printf("❌ Error sending budget warning for %s: %v\n", ws.Config().Name, err)
+	}
+}
+
+// describeLimits formats limits as lines
+func describeLimits(limits []budgetLimit) string {
+	lines := make([]string, len(limits))
+	for i, l := range limits {
+		lines[i] = "- " + l.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// handleBudget handles the /budget command: the budget status, or with
+// /budget override lifting the limits for the rest of the day (admins only)
+func (m *Manager) handleBudget(ctx context.Context, ws *WorkspaceBot, key session.Key, userID int64, r role, text string) error {
+	name := ws.Config().Name
+	now := time.Now()
+
+	if args := strings.Fields(text); len(args) > 1 {
+		if args[1] != "override" {
+			_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+				key,
+				"❌ Usage: /budget | /budget override",
+			))
+			return err
+		}
+		if r < roleAdmin {
+			return m.denyCommand(ctx, ws, key, "/budget override", roleAdmin)
+		}
+		m.ledger.Override(name, now)
+		fmt.Printf("💸 Budget of %s lifted for today by user %d\n", name, userID)
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			"✅ Budget limits are lifted until midnight.",
+		))
+		return err
+	}
+
+	limits := m.budgetLimits(ws, userID, now)
+	reply := "💵 No budget is configured for this workspace."
+	if len(limits) > 0 {
+		reply = "💵 Budget\n" + describeLimits(limits)
+		if m.ledger.Overridden(name, now) {
+			reply += "\n\n✅ Lifted by an admin until midnight."
+		}
+	}
+	_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+		key,
+		reply,
+	))
+	return err
+}
@@ -0,0 +1,239 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+
+	"github.com/mymmrac/telego"
+	"telecode/internal/checkpoint"
+	"telecode/internal/session"
+)
+
+// maxListedChanges is the number of reverted files listed after a restore
+const maxListedChanges = 30
+
+// checkpointStore returns the checkpoints of a chat's run directory. With
+// worktree isolation they are kept per session, since each has its own directory.
+func (ws *WorkspaceBot) checkpointStore(ctx context.Context, key session.Key, dir string) checkpoint.Store {
+	cfg := ws.Config()
+	scope := conversationSlug(key)
+	if dir != cfg.WorkingDir {
+		if active, ok := ws.Bot.ActiveSession(key); ok {
+			scope += "/" + active.Name
+		}
+	}
+	return checkpoint.Open(ctx, dir, cfg.CheckpointDir, scope)
+}
+
+// createCheckpoint snapshots the run directory before a prompt runs; failures
+// are logged and do not keep the prompt from running
+func (m *Manager) createCheckpoint(ctx context.Context, ws *WorkspaceBot, key session.Key, dir, prompt string) {
+	cfg := ws.Config()
+	if cfg.Checkpoints <= 0 {
+		return
+	}
+
+	gitCtx, cancel := context.WithTimeout(ctx, gitTimeout)
+	defer cancel()
+	store := ws.checkpointStore(gitCtx, key, dir)
+	if _, err := store.Create(gitCtx, checkpoint.KindRun, truncateRunes(prompt, 100)); err != nil {
+		fmt.Printf("❌ Failed to create checkpoint for %s in %s: %v\n", key, cfg.Name, err)
+		return
+	}
+	if err := store.Prune(gitCtx, cfg.Checkpoints); err != nil {
+		fmt.Printf("❌ Failed to prune checkpoints for %s in %s: %v\n", key, cfg.Name, err)
+	}
+}
+
+// requireCheckpoints tells the chat when checkpoints are disabled and reports whether they are enabled
+func (m *Manager) requireCheckpoints(ctx context.Context, ws *WorkspaceBot, key session.Key) (bool, error) {
+	if ws.Config().Checkpoints > 0 {
+		return true, nil
+	}
+	_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+		key,
+		"ℹ️ Checkpoints are disabled for this workspace.",
+	))
+	return false, err
+}
+
+// handleCheckpoints handles the /checkpoints command
+func (m *Manager) handleCheckpoints(ctx context.Context, ws *WorkspaceBot, key session.Key) error {
+	if ok, err := m.requireCheckpoints(ctx, ws, key); !ok {
+		return err
+	}
+
+	gitCtx, cancel := context.WithTimeout(ctx, gitTimeout)
+	defer cancel()
+	checkpoints, err := ws.checkpointStore(gitCtx, key, ws.gitDir(key)).List(gitCtx)
+	if err != nil {
+		return m.sendGitError(ctx, ws, key, err)
+	}
+	if len(checkpoints) == 0 {
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			"🕰 No checkpoints yet, one is taken before every prompt.",
+		))
+		return err
+	}
+
+	var sb strings.Builder
+	sb.WriteString("🕰 <b>Checkpoints</b> (newest first)\n")
+	for _, c := range checkpoints {
+		fmt.Fprintf(&sb, "\n<code>%d</code> · %s · %s", c.ID, c.Time.Format("Jan 2 15:04"), html.EscapeString(describeCheckpoint(c)))
+	}
+	sb.WriteString("\n\nUse /undo to revert the last run or /restore &lt;id&gt; to go back further.")
+
+	_, err = ws.TgBot.SendMessage(ctx, chatMessage(
+		key,
+		sb.String(),
+	).WithParseMode(telego.ModeHTML))
+	return err
+}
+
+// handleUndo handles the /undo command: restores the checkpoint taken before the last run
+func (m *Manager) handleUndo(ctx context.Context, ws *WorkspaceBot, key session.Key) error {
+	if ok, err := m.requireCheckpoints(ctx, ws, key); !ok {
+		return err
+	}
+
+	gitCtx, cancel := context.WithTimeout(ctx, gitTimeout)
+	defer cancel()
+	checkpoints, err := ws.checkpointStore(gitCtx, key, ws.gitDir(key)).List(gitCtx)
+	if err != nil {
+		return m.sendGitError(ctx, ws, key, err)
+	}
+	for _, c := range checkpoints {
+		if c.Kind == checkpoint.KindRun {
+			return m.restoreCheckpoint(ctx, ws, key, c)
+		}
+	}
+	_, err = ws.TgBot.SendMessage(ctx, chatMessage(
+		key,
+		"🕰 Nothing to undo yet.",
+	))
+	return err
+}
+
+// handleRestore handles the /restore command
+func (m *Manager) handleRestore(ctx context.Context, ws *WorkspaceBot, key session.Key, text string) error {
+	if ok, err := m.requireCheckpoints(ctx, ws, key); !ok {
+		return err
+	}
+
+	args := strings.Fields(text)
+	var id int
+	var err error
+	if len(args) > 1 {
+		id, err = strconv.Atoi(strings.TrimPrefix(args[1], "#"))
+	}
+	if len(args) < 2 || err != nil {
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			"❌ Usage: /restore <id> (see /checkpoints)",
+		))
+		return err
+	}
+
+	gitCtx, cancel := context.WithTimeout(ctx, gitTimeout)
+	defer cancel()
+	checkpoints, err := ws.checkpointStore(gitCtx, key, ws.gitDir(key)).List(gitCtx)
+	if err != nil {
+		return m.sendGitError(ctx, ws, key, err)
+	}
+	for _, c := range checkpoints {
+		if c.ID == id {
+			return m.restoreCheckpoint(ctx, ws, key, c)
+		}
+	}
+	_, err = ws.TgBot.SendMessage(ctx, chatMessage(
+		key,
+		fmt.Sprintf("❌ Checkpoint %d not found, see /checkpoints.", id),
+	))
+	return err
+}
+
+// restoreCheckpoint reverts the run directory to a checkpoint and reports the
+// reverted files. The current state is checkpointed first, so a restore can be undone too.
+func (m *Manager) restoreCheckpoint(ctx context.Context, ws *WorkspaceBot, key session.Key, c checkpoint.Checkpoint) error {
+	// Without worktree isolation every chat runs in the same directory. No run
+	// may use it while its files are rewritten, and none may start until then.
+	dir := ws.gitDir(key)
+	lock := m.dirs.get(dir)
+	if !lock.TryLock() {
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			"⏳ An agent is working in this directory, wait for it or /cancel it first.",
+		))
+		return err
+	}
+	defer lock.Unlock()
+
+	cfg := ws.Config()
+	gitCtx, cancel := context.WithTimeout(ctx, gitTimeout)
+	defer cancel()
+	store := ws.checkpointStore(gitCtx, key, dir)
+
+	before, err := store.Create(gitCtx, checkpoint.KindRestore, fmt.Sprintf("#%d", c.ID))
+	if err != nil {
+		return m.sendGitError(ctx, ws, key, fmt.Errorf("failed to checkpoint the current state: %w", err))
+	}
+	changes, err := store.Restore(gitCtx, c.ID)
+	if err != nil {
+		return m.sendGitError(ctx, ws, key, err)
+	}
+	if len(changes) == 0 {
+		// Nothing changed, so the state before the restore is not worth keeping
+		_ = store.Delete(gitCtx, before.ID)
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			fmt.Sprintf("✨ The files already match checkpoint %d.", c.ID),
+		))
+		return err
+	}
+	if err := store.Prune(gitCtx, cfg.Checkpoints); err != nil {
+		fmt.Printf("❌ Failed to prune checkpoints for %s in %s: %v\n", key, cfg.Name, err)
+	}
+	fmt.Printf("↩️ Restored checkpoint %d for %s in %s (%d files)\n", c.ID, key, cfg.Name, len(changes))
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "↩️ <b>Restored checkpoint %d</b> (%s)\n%d file(s) reverted:\n",
+		c.ID, html.EscapeString(describeCheckpoint(c)), len(changes))
+	for i, change := range changes {
+		if i == maxListedChanges {
+			fmt.Fprintf(&sb, "… and %d more\n", len(changes)-i)
+			break
+		}
+		fmt.Fprintf(&sb, "%s <code>%s</code>\n", changeVerb(change.Status), html.EscapeString(change.Path))
+	}
+	fmt.Fprintf(&sb, "\nUse /restore %d to go back to the state before this restore.", before.ID)
+
+	_, err = ws.TgBot.SendMessage(ctx, chatMessage(
+		key,
+		sb.String(),
+	).WithParseMode(telego.ModeHTML))
+	return err
+}
+
+// describeCheckpoint describes when a checkpoint was taken
+func describeCheckpoint(c checkpoint.Checkpoint) string {
+	if c.Kind == checkpoint.KindRestore {
+		return "before restoring " + c.Label
+	}
+	return "before “" + c.Label + "”"
+}
+
+// changeVerb describes what a restore did to a file
+func changeVerb(status byte) string {
+	switch status {
+	case 'A':
+		return "restored"
+	case 'D':
+		return "deleted"
+	default:
+		return "reverted"
+	}
+}
@@ -0,0 +1,447 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+	"telecode/internal/config"
+	"telecode/internal/git"
+	"telecode/internal/session"
+)
+
+const (
+	// callbackCommitPrefix prefixes callback data of the /commit buttons
+	callbackCommitPrefix = "commit:"
+
+	// commitTTL is how long a commit can still be approved
+	commitTTL = time.Hour
+
+	// maxShownMessage is the longest commit message (in runes) shown for approval
+	maxShownMessage = 1500
+)
+
+// commitKey identifies the message showing a commit for approval
+type commitKey struct {
+	key       session.Key
+	messageID int
+}
+
+// pendingCommit is a commit waiting for approval
+type pendingCommit struct {
+	dir        string
+	branch     string
+	message    string
+	diff       git.Diff
+	tree       string // Staged tree shown for approval
+	stagedByUs bool   // The changes were staged by /commit and are unstaged on cancel or expiry
+}
+
+// commitRequests holds commits waiting for approval and the prompts asking
+// for a new commit message
+type commitRequests struct {
+	pending map[commitKey]*pendingCommit
+	edits   map[commitKey]commitKey // Edit prompt -> commit being edited
+	mu      sync.Mutex
+}
+
+// add stores a commit and forgets edit prompts of commits that are gone
+func (c *commitRequests) add(ck commitKey, p *pendingCommit) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for prompt, k := range c.edits {
+		if _, ok := c.pending[k]; !ok {
+			delete(c.edits, prompt)
+		}
+	}
+	c.pending[ck] = p
+}
+
+// get returns a copy of a pending commit
+func (c *commitRequests) get(ck commitKey) (pendingCommit, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.pending[ck]
+	if !ok {
+		return pendingCommit{}, false
+	}
+	return *p, true
+}
+
+// take removes and returns a pending commit
+func (c *commitRequests) take(ck commitKey) (pendingCommit, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.pending[ck]
+	if !ok {
+		return pendingCommit{}, false
+	}
+	delete(c.pending, ck)
+	return *p, true
+}
+
+// pendingIn reports whether a commit in dir is waiting for approval
+func (c *commitRequests) pendingIn(dir string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, p := range c.pending {
+		if p.dir == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// awaitEdit records that the reply to prompt is the new message of a commit
+func (c *commitRequests) awaitEdit(prompt, ck commitKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.edits[prompt] = ck
+}
+
+// edit sets the message of the commit whose edit prompt was replied to
+func (c *commitRequests) edit(prompt commitKey, message string) (commitKey, pendingCommit, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ck, ok := c.edits[prompt]
+	if !ok {
+		return commitKey{}, pendingCommit{}, false
+	}
+	delete(c.edits, prompt)
+	p, ok := c.pending[ck]
+	if !ok {
+		return commitKey{}, pendingCommit{}, false
+	}
+	p.message = message
+	return ck, *p, true
+}
+
+// gitAuthor returns the identity of commits made in a workspace
+func gitAuthor(cfg config.WorkspaceConfig) git.Identity {
+	return git.Identity{Name: cfg.Git.AuthorName, Email: cfg.Git.AuthorEmail}
+}
+
+// handleCommit handles the /commit command: stages the changes (unless some
+// are staged already) and asks to approve the commit with the given or a
+// generated message
+func (m *Manager) handleCommit(ctx context.Context, ws *WorkspaceBot, key session.Key, text string) error {
+	if ws.isRunning(key) {
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			"⏳ The agent is still working, wait for it or /cancel it first.",
+		))
+		return err
+	}
+
+	var message string
+	if i := strings.IndexAny(text, " \n"); i >= 0 {
+		message = strings.TrimSpace(text[i:])
+	}
+
+	dir := ws.gitDir(key)
+	gitCtx, cancel := context.WithTimeout(ctx, gitTimeout)
+	defer cancel()
+	if !git.IsRepo(gitCtx, dir) {
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			"❌ The working directory is not a git repository.",
+		))
+		return err
+	}
+	branch, err := git.CurrentBranch(gitCtx, dir)
+	if err != nil {
+		return m.sendGitError(ctx, ws, key, err)
+	}
+
+	p := &pendingCommit{dir: dir, branch: branch, message: message}
+	if !git.HasStaged(gitCtx, dir) {
+		if err := git.StageAll(gitCtx, dir); err != nil {
+			return m.sendGitError(ctx, ws, key, err)
+		}
+		p.stagedByUs = true
+	}
+	if p.diff, err = git.DiffStaged(gitCtx, dir); err != nil {
+		return m.sendGitError(ctx, ws, key, err)
+	}
+	if p.tree, err = git.StagedTree(gitCtx, dir); err != nil {
+		return m.sendGitError(ctx, ws, key, err)
+	}
+	if len(p.diff.Files) == 0 {
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			"✨ Nothing to commit.",
+		))
+		return err
+	}
+	if p.message == "" {
+		changes, err := git.StagedChanges(gitCtx, dir)
+		if err != nil {
+			return m.sendGitError(ctx, ws, key, err)
+		}
+		if p.message, err = commitMessage(changes); err != nil {
+			return m.sendGitError(ctx, ws, key, err)
+		}
+	}
+
+	sent, err := ws.TgBot.SendMessage(ctx, chatMessage(
+		key,
+		renderCommit(ws.Config(), *p),
+	).WithParseMode(telego.ModeHTML).WithReplyMarkup(commitKeyboard()))
+	if err != nil {
+		return err
+	}
+	ck := commitKey{key: key, messageID: sent.MessageID}
+	ws.commits.add(ck, p)
+	time.AfterFunc(commitTTL, func() { m.expireCommit(ws, ck) })
+	return nil
+}
+
+// expireCommit drops a commit that was neither approved nor cancelled in time
+func (m *Manager) expireCommit(ws *WorkspaceBot, ck commitKey) {
+	p, ok := ws.commits.take(ck)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(m.ctx, gitTimeout)
+	defer cancel()
+	_, _ = ws.TgBot.EditMessageReplyMarkup(ctx, tu.EditMessageReplyMarkup(tu.ID(ck.key.ChatID), ck.messageID, nil))
+	if err := ws.unstageCommit(ctx, p); err != nil {
+		fmt.Printf("❌ Failed to unstage expired commit in %s for %s: %v\n", p.dir, ws.Config().Name, err)
+	}
+}
+
+// unstageCommit unstages the changes /commit staged for a dropped commit,
+// unless another commit of the same directory is still waiting for approval
+func (ws *WorkspaceBot) unstageCommit(ctx context.Context, p pendingCommit) error {
+	if !p.stagedByUs || ws.commits.pendingIn(p.dir) {
+		return nil
+	}
+	return git.Unstage(ctx, p.dir)
+}
+
+// handleCommitCallback approves, edits or cancels a commit after the user pressed a button
+func (m *Manager) handleCommitCallback(ctx context.Context, ws *WorkspaceBot, query *telego.CallbackQuery) error {
+	key := callbackKey(query)
+	ck := commitKey{key: key, messageID: query.Message.GetMessageID()}
+	removeButtons := func() {
+		_, _ = ws.TgBot.EditMessageReplyMarkup(ctx, tu.EditMessageReplyMarkup(tu.ID(key.ChatID), ck.messageID, nil))
+	}
+
+	if _, ok := ws.commits.get(ck); !ok {
+		removeButtons()
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			"⌛ This commit request expired, use /commit again.",
+		))
+		return err
+	}
+
+	switch strings.TrimPrefix(query.Data, callbackCommitPrefix) {
+	case "edit":
+		sent, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			"✏️ Reply to this message with the new commit message.",
+		).WithReplyMarkup(tu.ForceReply().WithInputFieldPlaceholder("Commit message")))
+		if err != nil {
+			return err
+		}
+		ws.commits.awaitEdit(commitKey{key: key, messageID: sent.MessageID}, ck)
+		return nil
+	case "approve":
+		p, ok := ws.commits.take(ck)
+		if !ok {
+			return nil
+		}
+		removeButtons()
+		return m.runCommit(ctx, ws, key, p)
+	default:
+		p, ok := ws.commits.take(ck)
+		if !ok {
+			return nil
+		}
+		removeButtons()
+		gitCtx, cancel := context.WithTimeout(ctx, gitTimeout)
+		defer cancel()
+		if err := ws.unstageCommit(gitCtx, p); err != nil {
+			return m.sendGitError(ctx, ws, key, err)
+		}
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			"🗑 Commit cancelled.",
+		))
+		return err
+	}
+}
+
+// handleCommitEdit takes a reply to an edit prompt as the new commit message
+// and reports whether the message was such a reply
+func (m *Manager) handleCommitEdit(ctx context.Context, ws *WorkspaceBot, key session.Key, message *telego.Message) (bool, error) {
+	text := strings.TrimSpace(message.Text)
+	if message.ReplyToMessage == nil || text == "" {
+		return false, nil
+	}
+	ck, p, ok := ws.commits.edit(commitKey{key: key, messageID: message.ReplyToMessage.MessageID}, text)
+	if !ok {
+		return false, nil
+	}
+
+	_, err := ws.TgBot.EditMessageText(ctx, tu.EditMessageText(
+		tu.ID(key.ChatID),
+		ck.messageID,
+		renderCommit(ws.Config(), p),
+	).WithParseMode(telego.ModeHTML).WithReplyMarkup(commitKeyboard()))
+	if err != nil {
+		return true, err
+	}
+	_, err = ws.TgBot.SendMessage(ctx, chatMessage(
+		key,
+		"✏️ Commit message updated, approve it above.",
+	))
+	return true, err
+}
+
+// runCommit commits an approved commit and pushes it if a remote is configured
+func (m *Manager) runCommit(ctx context.Context, ws *WorkspaceBot, key session.Key, p pendingCommit) error {
+	cfg := ws.Config()
+	gitCtx, cancel := context.WithTimeout(ctx, gitTimeout)
+	defer cancel()
+
+	// An agent run may have staged more changes since the diff was shown
+	tree, err := git.StagedTree(gitCtx, p.dir)
+	if err != nil {
+		return m.sendGitError(ctx, ws, key, err)
+	}
+	if tree != p.tree {
+		if err := ws.unstageCommit(gitCtx, p); err != nil {
+			fmt.Printf("❌ Failed to unstage changed commit in %s for %s: %v\n", p.dir, cfg.Name, err)
+		}
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			"⚠️ The staged changes differ from the approved diff, nothing was committed. Use /commit again.",
+		))
+		return err
+	}
+
+	hash, err := git.CommitStaged(gitCtx, p.dir, p.message, gitAuthor(cfg))
+	if err != nil {
+		return m.sendGitError(ctx, ws, key, err)
+	}
+	fmt.Printf("📦 Committed %s on %s for %s\n", hash, p.branch, cfg.Name)
+
+	subject, _, _ := strings.Cut(p.message, "\n")
+	reply := fmt.Sprintf("✅ Committed <code>%s</code> on <code>%s</code>: %s",
+		html.EscapeString(hash), html.EscapeString(p.branch), html.EscapeString(subject))
+
+	if cfg.Git.Remote != "" {
+		branch := cfg.Git.Branch
+		if branch == "" {
+			branch = p.branch
+		}
+		target := html.EscapeString(cfg.Git.Remote + "/" + branch)
+		if err := git.Push(gitCtx, p.dir, cfg.Git.Remote, branch); err != nil {
+			fmt.Printf("❌ Push to %s/%s failed for %s: %v\n", cfg.Git.Remote, branch, cfg.Name, err)
+			reply += fmt.Sprintf("\n⚠️ Push to <code>%s</code> failed: %s", target, html.EscapeString(err.Error()))
+		} else {
+			reply += fmt.Sprintf("\n⬆️ Pushed to <code>%s</code>", target)
+		}
+	}
+
+	_, err = ws.TgBot.SendMessage(ctx, chatMessage(
+		key,
+		reply,
+	).WithParseMode(telego.ModeHTML))
+	return err
+}
+
+// renderCommit shows a pending commit for approval
+func renderCommit(cfg config.WorkspaceConfig, p pendingCommit) string {
+	message := p.message
+	if runes := []rune(message); len(runes) > maxShownMessage {
+		message = string(runes[:maxShownMessage]) + "…"
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "📦 <b>Commit to <code>%s</code></b>\n\n", html.EscapeString(p.branch))
+	sb.WriteString(diffSummary(p.diff))
+	fmt.Fprintf(&sb, "\n\n<b>Message</b>\n<pre>%s</pre>", html.EscapeString(message))
+	if cfg.Git.Remote != "" {
+		branch := cfg.Git.Branch
+		if branch == "" {
+			branch = p.branch
+		}
+		fmt.Fprintf(&sb, "\n⬆️ Pushes to <code>%s</code>", html.EscapeString(cfg.Git.Remote+"/"+branch))
+	}
+	return sb.String()
+}
+
+// commitKeyboard returns the Approve / Edit / Cancel buttons
+func commitKeyboard() *telego.InlineKeyboardMarkup {
+	return tu.InlineKeyboard(tu.InlineKeyboardRow(
+		tu.InlineKeyboardButton("✅ Approve").WithCallbackData(callbackCommitPrefix+"approve"),
+		tu.InlineKeyboardButton("✏️ Edit").WithCallbackData(callbackCommitPrefix+"edit"),
+		tu.InlineKeyboardButton("❌ Cancel").WithCallbackData(callbackCommitPrefix+"cancel"),
+	))
+}
+
+// commitMessage generates a commit message from the staged changes, e.g.
+// "Update handlers.go and manager.go" with the files listed in the body
+func commitMessage(changes []git.Change) (string, error) {
+	if len(changes) == 0 {
+		return "", errors.New("no staged changes to describe")
+	}
+
+	verb := func(status byte) string {
+		switch status {
+		case 'A':
+			return "Add"
+		case 'D':
+			return "Remove"
+		default:
+			return "Update"
+		}
+	}
+
+	subjectVerb := verb(changes[0].Status)
+	names := make([]string, len(changes))
+	dirs := make(map[string]bool)
+	for i, c := range changes {
+		if verb(c.Status) != subjectVerb {
+			subjectVerb = "Update"
+		}
+		names[i] = filepath.Base(c.Path)
+		dirs[filepath.Dir(c.Path)] = true
+	}
+
+	var subject string
+	switch {
+	case len(names) == 1:
+		subject = fmt.Sprintf("%s %s", subjectVerb, names[0])
+	case len(names) <= 3:
+		subject = fmt.Sprintf("%s %s and %s", subjectVerb, strings.Join(names[:len(names)-1], ", "), names[len(names)-1])
+	default:
+		subject = fmt.Sprintf("%s %d files", subjectVerb, len(names))
+		if len(dirs) == 1 && !dirs["."] {
+			subject += " in " + filepath.Dir(changes[0].Path)
+		}
+	}
+	if len(changes) == 1 {
+		return subject, nil
+	}
+
+	lines := []string{subject, ""}
+	for i, c := range changes {
+		if i == maxSummaryFiles {
+			lines = append(lines, fmt.Sprintf("- ... and %d more", len(changes)-i))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("- %s %s", verb(c.Status), c.Path))
+	}
+	return strings.Join(lines, "\n"), nil
+}
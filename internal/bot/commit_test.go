@@ -0,0 +1,31 @@
+package bot
+
+import (
+	"testing"
+
+	"telecode/internal/git"
+)
+
+func TestCommitMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		changes []git.Change
+		want    string
+		wantErr bool
+	}{
+		{"no changes", nil, "", true},
+		{"one file", []git.Change{{Status: 'A', Path: "internal/bot/commit.go"}}, "Add commit.go", false},
+		{"mixed", []git.Change{{Status: 'A', Path: "a.go"}, {Status: 'D', Path: "b.go"}}, "Update a.go and b.go\n\n- Add a.go\n- Remove b.go", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := commitMessage(tt.changes)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("message = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
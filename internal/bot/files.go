@@ -0,0 +1,250 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mymmrac/telego"
+	"telecode/internal/session"
+)
+
+// mediaGroupDelay is how long to wait for the other messages of an album
+const mediaGroupDelay = 1500 * time.Millisecond
+
+// unsafeFileChars matches characters not kept in saved file names
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// attachment is a downloaded file waiting to be passed to the executor
+type attachment struct {
+	path    string
+	cleanup func()
+}
+
+// mediaGroup collects the attachments of an album sent as several messages
+type mediaGroup struct {
+	key         session.Key
+	user        *telego.User // Sender of the first message
+	role        role
+	caption     string
+	prompt      string
+	attachments []attachment
+	timer       *time.Timer
+}
+
+// mediaGroups holds albums that are still being received
+type mediaGroups struct {
+	groups map[string]*mediaGroup
+	mu     sync.Mutex
+}
+
+// handlePhotoMessage handles image messages
+func (m *Manager) handlePhotoMessage(ctx context.Context, ws *WorkspaceBot, message *telego.Message, r role) error {
+	// Select largest image
+	photoSizes := message.Photo
+	largestPhoto := photoSizes[len(photoSizes)-1]
+
+	name := fmt.Sprintf("image_%d.jpg", message.MessageID)
+	return m.handleAttachment(ctx, ws, message, r, largestPhoto.FileID, int64(largestPhoto.FileSize), name, "Analyze this image")
+}
+
+// handleDocumentMessage handles files sent as documents (PDFs, logs, patches, uncompressed images)
+func (m *Manager) handleDocumentMessage(ctx context.Context, ws *WorkspaceBot, message *telego.Message, r role) error {
+	doc := message.Document
+
+	name := doc.FileName
+	if name == "" {
+		name = fmt.Sprintf("file_%d", message.MessageID)
+	}
+	return m.handleAttachment(ctx, ws, message, r, doc.FileID, doc.FileSize, name, "Analyze the attached file")
+}
+
+// handleAttachment downloads a file and runs the caption as prompt with it attached.
+// Albums are collected and run as one prompt with all their files.
+func (m *Manager) handleAttachment(ctx context.Context, ws *WorkspaceBot, message *telego.Message, r role, fileID string, size int64, name, defaultPrompt string) error {
+	key := keyFor(message)
+
+	maxSize := int64(ws.Config().MaxFileSizeMB) << 20
+	if size > maxSize {
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			fmt.Sprintf("❌ File is too large (%.1f MB, limit %d MB)", float64(size)/(1<<20), ws.Config().MaxFileSizeMB),
+		))
+		return err
+	}
+
+	// Get file info
+	file, err := ws.TgBot.GetFile(ctx, &telego.GetFileParams{FileID: fileID})
+	if err != nil {
+		_, _ = ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			"❌ Failed to get file info",
+		))
+		return err
+	}
+
+	att, err := m.saveAttachment(ctx, ws, key, r, file.FilePath, name, maxSize)
+	if err != nil {
+		_, _ = ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			"❌ Failed to download file",
+		))
+		return err
+	}
+
+	if message.MediaGroupID == "" {
+		// Process prompt
+		prompt := ws.stripMention(message.Caption)
+		if prompt == "" {
+			prompt = defaultPrompt
+		}
+		return m.handleMessage(ctx, ws, key, message.From, r, prompt, []string{att.path}, att.cleanup)
+	}
+
+	ws.addToMediaGroup(message, r, att, defaultPrompt, func(g *mediaGroup) {
+		prompt := g.caption
+		if prompt == "" {
+			prompt = g.prompt
+		}
+		var paths []string
+		for _, a := range g.attachments {
+			paths = append(paths, a.path)
+		}
+		cleanup := func() {
+			for _, a := range g.attachments {
+				a.cleanup()
+			}
+		}
+		if err := m.handleMessage(ctx, ws, g.key, g.user, g.role, prompt, paths, cleanup); err != nil {
+			fmt.Printf("❌ Error handling album for %s: %v\n", ws.Config().Name, err)
+		}
+	})
+	return nil
+}
+
+// saveAttachment downloads a Telegram file into the workspace inbox if one is
+// configured (kept), otherwise into a temp file (removed after the run).
+// Files of read-only users always go to a temp file, since the inbox may be
+// inside the working tree they must not change.
+func (m *Manager) saveAttachment(ctx context.Context, ws *WorkspaceBot, key session.Key, r role, filePath, name string, maxSize int64) (attachment, error) {
+	name = unsafeFileChars.ReplaceAllString(filepath.Base(name), "_")
+
+	if ws.Config().InboxDir != "" && r >= roleOperator {
+		dir := ws.Config().InboxDir
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(ws.Config().WorkingDir, dir)
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return attachment{}, err
+		}
+		localPath := filepath.Join(dir, time.Now().Format("20060102-150405")+"_"+name)
+		if err := downloadFile(ctx, ws.Config().BotToken, filePath, localPath, maxSize); err != nil {
+			os.Remove(localPath)
+			return attachment{}, err
+		}
+		return attachment{path: localPath, cleanup: func() {}}, nil
+	}
+
+	// Download to temp file
+	tempDir, err := os.MkdirTemp("", fmt.Sprintf("telecode_%d_", key.ChatID))
+	if err != nil {
+		return attachment{}, err
+	}
+	cleanup := func() { os.RemoveAll(tempDir) } // Clean up temp file once the prompt has run
+	localPath := filepath.Join(tempDir, name)
+	if err := downloadFile(ctx, ws.Config().BotToken, filePath, localPath, maxSize); err != nil {
+		cleanup()
+		return attachment{}, err
+	}
+	return attachment{path: localPath, cleanup: cleanup}, nil
+}
+
+// addToMediaGroup adds an attachment to its album and (re)starts the timer
+// that submits the album once no more messages arrive
+func (ws *WorkspaceBot) addToMediaGroup(message *telego.Message, r role, att attachment, defaultPrompt string, submit func(*mediaGroup)) {
+	ws.albums.mu.Lock()
+	defer ws.albums.mu.Unlock()
+
+	id := message.MediaGroupID
+	g, ok := ws.albums.groups[id]
+	if !ok {
+		g = &mediaGroup{key: keyFor(message), user: message.From, role: r, prompt: defaultPrompt}
+		ws.albums.groups[id] = g
+		g.timer = time.AfterFunc(mediaGroupDelay, func() {
+			ws.albums.mu.Lock()
+			delete(ws.albums.groups, id)
+			ws.albums.mu.Unlock()
+			submit(g)
+		})
+	} else {
+		g.timer.Reset(mediaGroupDelay)
+	}
+
+	// The caption is set on one of the album's messages only
+	if message.Caption != "" {
+		g.caption = ws.stripMention(message.Caption)
+	}
+	if defaultPrompt != g.prompt {
+		g.prompt = "Analyze the attached files"
+	}
+	// The album runs with the lowest role of its senders
+	g.role = min(g.role, r)
+	g.attachments = append(g.attachments, att)
+}
+
+// has reports whether an album is being collected
+func (g *mediaGroups) has(id string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	_, ok := g.groups[id]
+	return ok
+}
+
+// downloadFile downloads a file from Telegram, failing if it exceeds maxSize bytes
+func downloadFile(ctx context.Context, botToken, filePath, localPath string, maxSize int64) error {
+	url := fmt.Sprintf("https://api.telegram.org/file/bot%s/%s", botToken, filePath)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download failed: %s", resp.Status)
+	}
+
+	out, err := os.Create(localPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	n, err := io.Copy(out, io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return err
+	}
+	if n > maxSize {
+		return fmt.Errorf("file exceeds %d bytes", maxSize)
+	}
+	return nil
+}
+
+// describeFiles returns the base names of files for display
+func describeFiles(files []string) string {
+	names := make([]string, len(files))
+	for i, f := range files {
+		names[i] = filepath.Base(f)
+	}
+	return strings.Join(names, ", ")
+}
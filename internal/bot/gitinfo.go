@@ -0,0 +1,224 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mymmrac/telego"
+	"telecode/internal/git"
+	"telecode/internal/session"
+)
+
+const (
+	// maxInlineDiff is the longest diff (in escaped characters) shown in the message itself
+	maxInlineDiff = 3000
+
+	// maxSummaryFiles is the number of files listed in a diff summary
+	maxSummaryFiles = 30
+
+	// defaultLogCount and maxLogCount bound the commits listed by /log
+	defaultLogCount = 10
+	maxLogCount     = 50
+)
+
+// gitDir returns the directory the git commands of a chat look at: the
+// worktree of the active session with worktree isolation, otherwise the working directory
+func (ws *WorkspaceBot) gitDir(key session.Key) string {
+	cfg := ws.Config()
+	if cfg.Isolation == isolationWorktree {
+		if active, ok := ws.Bot.ActiveSession(key); ok {
+			if path, err := sessionWorktreePath(cfg, key, active.Name); err == nil {
+				if _, err := os.Stat(filepath.Join(path, ".git")); err == nil {
+					return path
+				}
+			}
+		}
+	}
+	return cfg.WorkingDir
+}
+
+// handleDiff handles the /diff command: changes of the working tree against HEAD,
+// optionally limited to a path
+func (m *Manager) handleDiff(ctx context.Context, ws *WorkspaceBot, key session.Key, text string) error {
+	var path string
+	if args := strings.Fields(text); len(args) > 1 {
+		path = args[1]
+	}
+
+	gitCtx, cancel := context.WithTimeout(ctx, gitTimeout)
+	defer cancel()
+	d, err := git.DiffHead(gitCtx, ws.gitDir(key), path)
+	if err != nil {
+		return m.sendGitError(ctx, ws, key, err)
+	}
+
+	title := "📝 <b>Changes</b> against HEAD"
+	if path != "" {
+		title += " in <code>" + html.EscapeString(path) + "</code>"
+	}
+	return sendDiff(ctx, ws.TgBot, key, title, "changes.diff", d)
+}
+
+// handleShow handles the /show command: a commit and its changes
+func (m *Manager) handleShow(ctx context.Context, ws *WorkspaceBot, key session.Key, text string) error {
+	args := strings.Fields(text)
+	if len(args) < 2 {
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			"❌ Usage: /show <rev>",
+		))
+		return err
+	}
+
+	gitCtx, cancel := context.WithTimeout(ctx, gitTimeout)
+	defer cancel()
+	c, d, err := git.Show(gitCtx, ws.gitDir(key), args[1])
+	if err != nil {
+		return m.sendGitError(ctx, ws, key, err)
+	}
+
+	title := fmt.Sprintf("📌 <code>%s</code> <b>%s</b>\n%s, %s",
+		html.EscapeString(c.Hash), html.EscapeString(c.Subject), html.EscapeString(c.Author), html.EscapeString(c.Date))
+	if c.Body != "" {
+		title += "\n\n" + html.EscapeString(truncateBody(c.Body, 800))
+	}
+	return sendDiff(ctx, ws.TgBot, key, title, c.Hash+".diff", d)
+}
+
+// handleLog handles the /log command: the last commits of the checked out branch
+func (m *Manager) handleLog(ctx context.Context, ws *WorkspaceBot, key session.Key, text string) error {
+	n := defaultLogCount
+	if args := strings.Fields(text); len(args) > 1 {
+		var err error
+		if n, err = strconv.Atoi(args[1]); err != nil || n < 1 {
+			_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+				key,
+				"❌ Usage: /log [n]",
+			))
+			return err
+		}
+		n = min(n, maxLogCount)
+	}
+
+	gitCtx, cancel := context.WithTimeout(ctx, gitTimeout)
+	defer cancel()
+	commits, err := git.Log(gitCtx, ws.gitDir(key), n)
+	if err != nil {
+		return m.sendGitError(ctx, ws, key, err)
+	}
+	if len(commits) == 0 {
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			"📜 No commits yet.",
+		))
+		return err
+	}
+
+	var sb strings.Builder
+	sb.WriteString("📜 <b>Log</b>\n")
+	for _, c := range commits {
+		fmt.Fprintf(&sb, "\n<code>%s</code> %s\n<i>%s, %s</i>",
+			html.EscapeString(c.Hash), html.EscapeString(truncateRunes(c.Subject, 80)),
+			html.EscapeString(c.Author), html.EscapeString(c.Date))
+	}
+	sb.WriteString("\n\nUse /show &lt;hash&gt; for the changes of a commit.")
+
+	_, err = ws.TgBot.SendMessage(ctx, chatMessage(
+		key,
+		sb.String(),
+	).WithParseMode(telego.ModeHTML))
+	return err
+}
+
+// handleGitStatus handles the /gitstatus command: the branch and changed files
+func (m *Manager) handleGitStatus(ctx context.Context, ws *WorkspaceBot, key session.Key) error {
+	gitCtx, cancel := context.WithTimeout(ctx, gitTimeout)
+	defer cancel()
+	status, err := git.Status(gitCtx, ws.gitDir(key))
+	if err != nil {
+		return m.sendGitError(ctx, ws, key, err)
+	}
+
+	escaped := html.EscapeString(status)
+	if len(escaped) > maxInlineDiff {
+		if _, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			fmt.Sprintf("📂 %d changed files, see the attached status.", strings.Count(status, "\n")),
+		)); err != nil {
+			return err
+		}
+		return sendFile(ctx, ws.TgBot, key, "status.txt", status)
+	}
+
+	reply := "📂 <b>Git status</b>\n<pre>" + escaped + "</pre>"
+	if !strings.Contains(status, "\n") {
+		reply += "\n✨ Working tree clean"
+	}
+	_, err = ws.TgBot.SendMessage(ctx, chatMessage(
+		key,
+		reply,
+	).WithParseMode(telego.ModeHTML))
+	return err
+}
+
+// sendDiff sends a diff with a per-file summary; the patch itself is shown
+// inline with diff highlighting if it is short, otherwise attached as name
+func sendDiff(ctx context.Context, bot *telego.Bot, key session.Key, title, name string, d git.Diff) error {
+	if len(d.Files) == 0 {
+		_, err := bot.SendMessage(ctx, chatMessage(
+			key,
+			title+"\n\n✨ No changes",
+		).WithParseMode(telego.ModeHTML))
+		return err
+	}
+
+	patch := html.EscapeString(d.Patch)
+	inline := len(patch) <= maxInlineDiff
+
+	text := title + "\n\n" + diffSummary(d)
+	if inline {
+		text += "\n<pre><code class=\"language-diff\">" + patch + "</code></pre>"
+	}
+	if _, err := bot.SendMessage(ctx, chatMessage(
+		key,
+		text,
+	).WithParseMode(telego.ModeHTML)); err != nil {
+		return err
+	}
+	if inline {
+		return nil
+	}
+	return sendFile(ctx, bot, key, name, d.Patch+"\n")
+}
+
+// diffSummary lists the files of a diff with their added and removed lines
+func diffSummary(d git.Diff) string {
+	var sb strings.Builder
+	for i, f := range d.Files {
+		if i == maxSummaryFiles {
+			fmt.Fprintf(&sb, "… and %d more\n", len(d.Files)-i)
+			break
+		}
+		if f.Binary {
+			fmt.Fprintf(&sb, "<code>%s</code> binary\n", html.EscapeString(f.Path))
+			continue
+		}
+		fmt.Fprintf(&sb, "<code>%s</code> +%d −%d\n", html.EscapeString(f.Path), f.Added, f.Removed)
+	}
+	added, removed := d.Totals()
+	fmt.Fprintf(&sb, "<b>%d file(s), +%d −%d</b>", len(d.Files), added, removed)
+	return sb.String()
+}
+
+// truncateBody shortens a commit message body to n runes, keeping its line breaks
+func truncateBody(body string, n int) string {
+	if runes := []rune(body); len(runes) > n {
+		return string(runes[:n]) + "…"
+	}
+	return body
+}
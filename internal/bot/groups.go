@@ -0,0 +1,50 @@
+package bot
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/mymmrac/telego"
+)
+
+// isForMe reports whether a command addressed to target ("" for none) is meant for this bot
+func (ws *WorkspaceBot) isForMe(target string) bool {
+	return target == "" || ws.me == nil || strings.EqualFold(target, ws.me.Username)
+}
+
+// addressed reports whether a message is meant as a prompt. In private chats,
+// and in groups unless require_mention is set, every message is; otherwise it
+// must mention the bot or reply to one of its messages.
+func (ws *WorkspaceBot) addressed(message *telego.Message) bool {
+	if message.Chat.Type == telego.ChatTypePrivate || !ws.Config().RequireMention || ws.me == nil {
+		return true
+	}
+
+	// Only one message of an album carries the caption mentioning the bot
+	if message.MediaGroupID != "" && ws.albums.has(message.MediaGroupID) {
+		return true
+	}
+
+	if reply := message.ReplyToMessage; reply != nil && reply.From != nil && reply.From.ID == ws.me.ID {
+		return true
+	}
+
+	text := message.Text
+	if text == "" {
+		text = message.Caption
+	}
+	return ws.mentionRegex().MatchString(text)
+}
+
+// stripMention removes mentions of the bot from a prompt
+func (ws *WorkspaceBot) stripMention(text string) string {
+	if ws.me == nil || ws.me.Username == "" {
+		return text
+	}
+	return strings.TrimSpace(ws.mentionRegex().ReplaceAllString(text, ""))
+}
+
+// mentionRegex matches "@botname" as a whole word, case-insensitively
+func (ws *WorkspaceBot) mentionRegex() *regexp.Regexp {
+	return regexp.MustCompile(`(?i)@` + regexp.QuoteMeta(ws.me.Username) + `\b`)
+}
@@ -2,120 +2,306 @@ package bot
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
-	"os"
+	"html"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/mymmrac/telego"
 	tu "github.com/mymmrac/telego/telegoutil"
+	"telecode/internal/session"
 )
 
 // handleNewSession handles the /new command
-func (m *Manager) handleNewSession(ctx context.Context, ws *WorkspaceBot, chatID int64) error {
-	ws.Bot.NewSession(chatID)
-	_, err := ws.TgBot.SendMessage(ctx, tu.Message(
-		tu.ID(chatID),
-		"✅ **New session started!**\n\nYou can now send your message.",
-	).WithParseMode(telego.ModeMarkdown))
+func (m *Manager) handleNewSession(ctx context.Context, ws *WorkspaceBot, key session.Key, text string) error {
+	var name string
+	if args := strings.Fields(text); len(args) > 1 {
+		name = args[1]
+	}
+
+	s, err := ws.Bot.NewSession(key, name)
+	if err != nil {
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			fmt.Sprintf("❌ %v", err),
+		))
+		return err
+	}
+
+	_, err = ws.TgBot.SendMessage(ctx, chatMessage(
+		key,
+		fmt.Sprintf("✅ <b>New session <code>%s</code> started!</b>\n\nYou can now send your message. Use /sessions to go back to earlier ones.", html.EscapeString(s.Name)),
+	).WithParseMode(telego.ModeHTML))
 	return err
 }
 
 // handleStatus handles the /status command
-func (m *Manager) handleStatus(ctx context.Context, ws *WorkspaceBot, chatID int64) error {
-	cli, sessionID := ws.Bot.GetStatus(chatID)
-
-	statusMsg := fmt.Sprintf("📊 **Current Status**\n"+
-		"- Workspace: `%s`\n"+
-		"- Working Dir: `%s`\n"+
-		"- CLI: `%s`\n"+
-		"- Session: `%s`",
-		ws.Config.Name, ws.Config.WorkingDir, cli, sessionID)
-
-	_, err := ws.TgBot.SendMessage(ctx, tu.Message(
-		tu.ID(chatID),
+func (m *Manager) handleStatus(ctx context.Context, ws *WorkspaceBot, key session.Key, r role) error {
+	cli, sessionName, sessionID := ws.Bot.GetStatus(key)
+
+	statusMsg := fmt.Sprintf("📊 <b>Current Status</b>\n"+
+		"- Workspace: <code>%s</code>\n"+
+		"- Working Dir: <code>%s</code>\n"+
+		"- CLI: <code>%s</code>\n"+
+		"- Model: <code>%s</code>\n"+
+		"- Session: <code>%s</code> (<code>%s</code>)\n"+
+		"- Your Role: <code>%s</code>",
+		html.EscapeString(ws.Config().Name), html.EscapeString(ws.Config().WorkingDir),
+		html.EscapeString(cli), html.EscapeString(modelName(ws.Bot.GetModel(key))),
+		html.EscapeString(sessionName), html.EscapeString(sessionID), r)
+	if r == roleReadOnly {
+		statusMsg += " (prompts run in plan mode)"
+	}
+	if ws.Config().Isolation == isolationWorktree {
+		if active, ok := ws.Bot.ActiveSession(key); ok {
+			statusMsg += fmt.Sprintf("\n- Branch: <code>%s</code>", html.EscapeString(sessionBranch(key, active.Name)))
+		}
+	}
+
+	_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+		key,
 		statusMsg,
-	).WithParseMode(telego.ModeMarkdown))
+	).WithParseMode(telego.ModeHTML))
 	return err
 }
 
 // handleCLI handles the /cli command
-func (m *Manager) handleCLI(ctx context.Context, ws *WorkspaceBot, chatID int64, text string) error {
+func (m *Manager) handleCLI(ctx context.Context, ws *WorkspaceBot, key session.Key, text string) error {
 	args := strings.Fields(text)
 
 	if len(args) == 1 {
 		// Get current CLI
-		cli := ws.Bot.GetCLI(chatID)
-		_, err := ws.TgBot.SendMessage(ctx, tu.Message(
-			tu.ID(chatID),
-			fmt.Sprintf("📋 Current CLI: `%s`", cli),
-		).WithParseMode(telego.ModeMarkdown))
+		cli := ws.Bot.GetCLI(key)
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			fmt.Sprintf("📋 Current CLI: <code>%s</code>\nAvailable: <code>%s</code>",
+				html.EscapeString(cli), html.EscapeString(strings.Join(ws.Bot.CLIs(), " | "))),
+		).WithParseMode(telego.ModeHTML))
 		return err
 	}
 
 	// Change CLI
 	newCLI := args[1]
-	if newCLI != "claude" && newCLI != "opencode" {
-		_, err := ws.TgBot.SendMessage(ctx, tu.Message(
-			tu.ID(chatID),
-			"❌ Unsupported CLI. Use: claude | opencode",
+	if ws.Bot.GetExecutor(newCLI) == nil {
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			"❌ Unsupported CLI. Use: "+strings.Join(ws.Bot.CLIs(), " | "),
 		))
 		return err
 	}
 
-	if err := ws.Bot.SetCLI(chatID, newCLI); err != nil {
-		_, err := ws.TgBot.SendMessage(ctx, tu.Message(
-			tu.ID(chatID),
+	if err := ws.Bot.SetCLI(key, newCLI); err != nil {
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
 			fmt.Sprintf("❌ %v", err),
 		))
 		return err
 	}
 
-	_, err := ws.TgBot.SendMessage(ctx, tu.Message(
-		tu.ID(chatID),
-		fmt.Sprintf("✅ CLI changed to: `%s` (session reset)", newCLI),
-	).WithParseMode(telego.ModeMarkdown))
+	_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+		key,
+		fmt.Sprintf("✅ CLI changed to: <code>%s</code> (session reset)", html.EscapeString(newCLI)),
+	).WithParseMode(telego.ModeHTML))
 	return err
 }
 
-// handleStats handles the /stats command
-func (m *Manager) handleStats(ctx context.Context, ws *WorkspaceBot, chatID int64) error {
-	stats, err := ws.Bot.GetStats(chatID)
-	if err != nil {
-		_, err := ws.TgBot.SendMessage(ctx, tu.Message(
-			tu.ID(chatID),
-			fmt.Sprintf("❌ %v", err),
-		))
-		return err
+// handleCancel handles the /cancel command
+func (m *Manager) handleCancel(ctx context.Context, ws *WorkspaceBot, key session.Key) error {
+	text := "🛑 Cancelling the running agent..."
+	if !ws.cancelRun(key) {
+		text = "ℹ️ Nothing is running."
 	}
+	if pending := len(ws.scheduler.Pending(key)); pending > 0 {
+		text += fmt.Sprintf("\n%d queued prompt(s) will still run. Use /queue clear to drop them.", pending)
+	}
+	_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+		key,
+		text,
+	))
+	return err
+}
+
+// handleQueue handles the /queue command
+func (m *Manager) handleQueue(ctx context.Context, ws *WorkspaceBot, key session.Key, text string) error {
+	args := strings.Fields(text)
 
-	_, err = ws.TgBot.SendMessage(ctx, tu.Message(
-		tu.ID(chatID),
-		fmt.Sprintf("📊 **Statistics**\n```\n%s\n```", stats),
-	).WithParseMode(telego.ModeMarkdown))
+	var reply string
+	switch {
+	case len(args) == 1:
+		pending := ws.scheduler.Pending(key)
+		if len(pending) == 0 {
+			reply = "📭 No queued prompts."
+			break
+		}
+		var sb strings.Builder
+		sb.WriteString("🕒 Queued prompts:\n")
+		for i, j := range pending {
+			fmt.Fprintf(&sb, "%d. %s (%s ago)\n", i+1, truncateRunes(j.prompt, 60),
+				time.Since(j.queuedAt).Round(time.Second))
+			if len(j.files) > 0 {
+				fmt.Fprintf(&sb, "   📎 %s\n", describeFiles(j.files))
+			}
+		}
+		sb.WriteString("\nUse /queue drop <n> or /queue clear")
+		reply = sb.String()
+	case args[1] == "clear":
+		reply = fmt.Sprintf("🗑 Dropped %d queued prompt(s).", ws.scheduler.Clear(key))
+	case args[1] == "drop" && len(args) == 3:
+		position, err := strconv.Atoi(args[2])
+		if err != nil {
+			reply = "❌ Usage: /queue drop <n>"
+			break
+		}
+		j, ok := ws.scheduler.Drop(key, position)
+		if !ok {
+			reply = fmt.Sprintf("❌ No queued prompt at position %d", position)
+			break
+		}
+		reply = fmt.Sprintf("🗑 Dropped: %s", truncateRunes(j.prompt, 60))
+	default:
+		reply = "❌ Usage: /queue | /queue drop <n> | /queue clear"
+	}
+
+	_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+		key,
+		reply,
+	))
 	return err
 }
 
-// handleMessage handles regular messages
-func (m *Manager) handleMessage(ctx context.Context, ws *WorkspaceBot, chatID int64, prompt, imagePath string) error {
+// handleMessage handles regular messages by queueing them for the chat's worker.
+// user is the sender (nil if anonymous); cleanup (may be nil) is called once
+// the prompt has run or was dropped.
+func (m *Manager) handleMessage(ctx context.Context, ws *WorkspaceBot, key session.Key, user *telego.User, r role, prompt string, files []string, cleanup func()) error {
 	if prompt == "" {
+		if cleanup != nil {
+			cleanup()
+		}
 		return nil
 	}
 
+	j := &job{
+		ctx:      ctx,
+		key:      key,
+		prompt:   prompt,
+		files:    files,
+		readOnly: r < roleOperator,
+		cleanup:  cleanup,
+	}
+	if user != nil {
+		j.userID, j.userName = user.ID, displayName(user)
+	}
+	if exceeded := m.budgetExceeded(ws, j.userID); exceeded != nil {
+		j.finish()
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			"🚫 Budget exceeded, the prompt was not run:\n"+describeLimits(exceeded)+
+				"\n\nAn admin can lift the limits for today with /budget override.",
+		))
+		return err
+	}
+
+	j.run = func(ctx context.Context) {
+		if err := m.executePrompt(ctx, ws, j); err != nil {
+			fmt.Printf("❌ Error running prompt for %s: %v\n", ws.Config().Name, err)
+		}
+	}
+
+	position, waiting, err := ws.scheduler.Submit(j)
+	if errors.Is(err, errSchedulerClosed) {
+		j.finish()
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			"🛑 Telecode is shutting down, the prompt was not run",
+		))
+		return err
+	}
+	if err != nil {
+		j.finish()
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			fmt.Sprintf("❌ Queue is full (%d pending). Use /queue to manage it.", ws.Config().MaxQueueSize),
+		))
+		return err
+	}
+	if position > 0 {
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			fmt.Sprintf("🕒 Queued (position %d)", position),
+		))
+		return err
+	}
+	if waiting {
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			"🕒 Queued (waiting for a free slot)",
+		))
+		return err
+	}
+	return nil
+}
+
+// executePrompt runs a queued prompt and sends the result
+func (m *Manager) executePrompt(ctx context.Context, ws *WorkspaceBot, j *job) error {
+	key := j.key
+
+	// The budget may have been used up while the prompt was queued
+	if exceeded := m.budgetExceeded(ws, j.userID); exceeded != nil {
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			"🚫 Budget exceeded, the queued prompt was not run:\n"+describeLimits(exceeded),
+		))
+		return err
+	}
+
+	// With worktree isolation this creates the session's worktree on first use
+	dir, err := m.runDir(ctx, ws, key)
+	if err != nil {
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			fmt.Sprintf("❌ Failed to prepare the session worktree: %v", err),
+		))
+		return err
+	}
+
+	cli := ws.Bot.GetCLI(key)
+	exec := ws.Bot.GetExecutor(cli)
+
 	// Build command
-	cmd := ws.Bot.BuildCommand(chatID, prompt, imagePath)
+	cmd := ws.Bot.BuildCommand(key, j.prompt, j.files, j.readOnly)
 	if cmd == nil {
-		_, _ = ws.TgBot.SendMessage(ctx, tu.Message(
-			tu.ID(chatID),
-			"❌ Failed to build command",
+		text := "❌ Failed to build command"
+		if exec != nil && j.readOnly {
+			text = fmt.Sprintf("❌ The %s CLI has no read-only mode, ask an operator to run this prompt", cli)
+		}
+		_, _ = ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			text,
 		))
 		return nil
 	}
 
+	// Restores of the directory wait for the run, and the run for them
+	lock := m.dirs.get(dir)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	// Register the run so it can be stopped with /cancel
+	runCtx, ok := ws.startRun(ctx, key)
+	if !ok {
+		return fmt.Errorf("chat %s already has a running agent", key)
+	}
+	defer ws.finishRun(key)
+
+	// Snapshot the files so the run can be reverted with /undo
+	if !j.readOnly {
+		m.createCheckpoint(runCtx, ws, key, dir, j.prompt)
+	}
+
 	// Send typing action periodically while processing
-	typingCtx, cancelTyping := context.WithCancel(ctx)
+	typingCtx, cancelTyping := context.WithCancel(runCtx)
 	defer cancelTyping()
 	go func() {
 		ticker := time.NewTicker(4 * time.Second)
@@ -126,52 +312,76 @@ func (m *Manager) handleMessage(ctx context.Context, ws *WorkspaceBot, chatID in
 				return
 			case <-ticker.C:
 				_ = ws.TgBot.SendChatAction(ctx, &telego.SendChatActionParams{
-					ChatID: tu.ID(chatID),
-					Action: telego.ChatActionTyping,
+					ChatID:          tu.ID(key.ChatID),
+					MessageThreadID: key.ThreadID,
+					Action:          telego.ChatActionTyping,
 				})
 			}
 		}
 	}()
 
+	// Show live output in a single message that is edited in place
+	progress := startProgress(ctx, ws.TgBot, key, func(raw string) string {
+		return extractProgressText(exec, raw)
+	})
+
 	// Execute command with working directory
-	output := runCommandWithDir(cmd, ws.Config.WorkingDir, ws.Config.CommandTimeout)
+	started := time.Now()
+	output := runCommandWithDir(runCtx, cmd, dir, ws.Config().CommandTimeout, progress.Append)
+	warnings := m.recordUsage(ws, j, cli, exec, output, time.Since(started))
+	defer m.sendBudgetWarnings(ctx, ws, key, warnings)
 
 	// Save session ID (from raw output before JSON parsing)
-	ws.Bot.UpdateSessionFromOutput(chatID, ws.Bot.GetCLI(chatID), output)
-
-	// For OpenCode, extract text from JSON output
-	if ws.Bot.GetCLI(chatID) == "opencode" {
-		output = extractTextFromOpenCodeJSON(output)
+	ws.Bot.UpdateSessionFromOutput(key, cli, output, j.prompt)
+
+	if runCtx.Err() == context.Canceled && ctx.Err() == nil {
+		progress.Finish(ctx, "🛑 Cancelled")
+		_, sessionName, sessionID := ws.Bot.GetStatus(key)
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			fmt.Sprintf("🛑 <b>Run cancelled.</b>\n\nSession: <code>%s</code> (<code>%s</code>)",
+				html.EscapeString(sessionName), html.EscapeString(sessionID)),
+		).WithParseMode(telego.ModeHTML))
+		return err
 	}
+	progress.Finish(ctx, "✅ Done")
 
-	// Send result (chunked)
-	return sendChunks(ctx, ws.TgBot, chatID, output)
+	// Send result (chunked, or as a document if large)
+	return sendResponse(ctx, ws.TgBot, key, extractOutputText(exec, output), ws.Config().DocumentThreshold)
 }
 
-// sendChunks splits and sends long messages
-func sendChunks(ctx context.Context, bot *telego.Bot, chatID int64, text string) error {
+// sendChunks splits and sends long Markdown messages as Telegram HTML
+func sendChunks(ctx context.Context, bot *telego.Bot, key session.Key, text string) error {
 	const maxMessageLength = 4000
 
 	// Trim whitespace and check if empty
 	trimmedText := strings.TrimSpace(text)
 	if trimmedText == "" {
-		_, err := bot.SendMessage(ctx, tu.Message(
-			tu.ID(chatID),
+		_, err := bot.SendMessage(ctx, chatMessage(
+			key,
 			"(empty response)",
 		))
 		return err
 	}
 
-	chunks := chunkString(trimmedText, maxMessageLength)
-	for _, chunk := range chunks {
+	// Split the Markdown first so no chunk ends inside a tag or code fence;
+	// the limit applies to the rendered HTML
+	for _, chunk := range htmlChunks(trimmedText, maxMessageLength) {
 		// Ensure chunk is not empty after trimming
-		if strings.TrimSpace(chunk) == "" {
+		if strings.TrimSpace(chunk.markdown) == "" {
 			continue
 		}
-		_, err := bot.SendMessage(ctx, tu.Message(
-			tu.ID(chatID),
-			chunk,
-		))
+		_, err := bot.SendMessage(ctx, chatMessage(
+			key,
+			chunk.html,
+		).WithParseMode(telego.ModeHTML))
+		if err != nil {
+			// Fall back to plain text if Telegram rejects the markup
+			_, err = bot.SendMessage(ctx, chatMessage(
+				key,
+				chunk.markdown,
+			))
+		}
 		if err != nil {
 			return err
 		}
@@ -179,8 +389,9 @@ func sendChunks(ctx context.Context, bot *telego.Bot, chatID int64, text string)
 	return nil
 }
 
-// chunkString splits a string into chunks of specified size
+// chunkString splits a string into chunks of specified size (at least one rune)
 func chunkString(s string, size int) []string {
+	size = max(size, 1)
 	if len(s) <= size {
 		return []string{s}
 	}
@@ -208,60 +419,3 @@ func chunkString(s string, size int) []string {
 
 	return chunks
 }
-
-// handlePhotoMessage handles image messages
-func (m *Manager) handlePhotoMessage(ctx context.Context, ws *WorkspaceBot, message *telego.Message) error {
-	chatID := message.Chat.ID
-
-	// Select largest image
-	photoSizes := message.Photo
-	largestPhoto := photoSizes[len(photoSizes)-1]
-
-	// Get file info
-	file, err := ws.TgBot.GetFile(ctx, &telego.GetFileParams{FileID: largestPhoto.FileID})
-	if err != nil {
-		_, _ = ws.TgBot.SendMessage(ctx, tu.Message(
-			tu.ID(chatID),
-			"❌ Failed to get image info",
-		))
-		return err
-	}
-
-	// Download to temp file
-	tempPath := fmt.Sprintf("/tmp/telecode_img_%d_%d.jpg", chatID, time.Now().Unix())
-	if err := downloadFile(ws.Config.BotToken, file.FilePath, tempPath); err != nil {
-		_, _ = ws.TgBot.SendMessage(ctx, tu.Message(
-			tu.ID(chatID),
-			"❌ Failed to download image",
-		))
-		return err
-	}
-	defer os.Remove(tempPath) // Clean up temp file
-
-	// Process prompt
-	prompt := message.Caption
-	if prompt == "" {
-		prompt = "Analyze this image"
-	}
-
-	return m.handleMessage(ctx, ws, chatID, prompt, tempPath)
-}
-
-// downloadFile downloads a file from Telegram
-func downloadFile(botToken, filePath, localPath string) error {
-	url := fmt.Sprintf("https://api.telegram.org/file/bot%s/%s", botToken, filePath)
-	resp, err := http.Get(url)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	out, err := os.Create(localPath)
-	if err != nil {
-		return err
-	}
-	defer out.Close()
-
-	_, err = io.Copy(out, resp.Body)
-	return err
-}
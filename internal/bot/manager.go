@@ -2,146 +2,641 @@ package bot
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
 	"telecode/internal/config"
+	"telecode/internal/executor"
+	"telecode/internal/session"
+	"telecode/internal/usage"
 )
 
 // WorkspaceBot represents a single workspace with its bot instance
 type WorkspaceBot struct {
-	Config config.WorkspaceConfig
-	Bot    *Bot
-	TgBot  *telego.Bot
+	Bot   *Bot
+	TgBot *telego.Bot
+
+	config    config.WorkspaceConfig
+	configMu  sync.RWMutex
+	scheduler *scheduler
+	albums    mediaGroups
+	voice     transcripts
+	commits   commitRequests
+	runs      map[session.Key]context.CancelFunc
+	runsMu    sync.Mutex
+
+	// stopUpdates stops receiving updates and waits for the update loop to exit
+	stopUpdates func()
+
+	// me is the bot's own user, used to recognize mentions and replies in groups
+	me *telego.User
+}
+
+// Config returns the current workspace configuration
+func (ws *WorkspaceBot) Config() config.WorkspaceConfig {
+	ws.configMu.RLock()
+	defer ws.configMu.RUnlock()
+	return ws.config
+}
+
+// setConfig replaces the workspace configuration
+func (ws *WorkspaceBot) setConfig(cfg config.WorkspaceConfig) {
+	ws.configMu.Lock()
+	defer ws.configMu.Unlock()
+	ws.config = cfg
+}
+
+// startRun registers an in-flight execution for a chat and returns its context.
+// It returns false if the chat already has a running execution.
+func (ws *WorkspaceBot) startRun(ctx context.Context, key session.Key) (context.Context, bool) {
+	ws.runsMu.Lock()
+	defer ws.runsMu.Unlock()
+	if _, running := ws.runs[key]; running {
+		return nil, false
+	}
+	runCtx, cancel := context.WithCancel(ctx)
+	ws.runs[key] = cancel
+	return runCtx, true
+}
+
+// finishRun unregisters the execution for a chat
+func (ws *WorkspaceBot) finishRun(key session.Key) {
+	ws.runsMu.Lock()
+	defer ws.runsMu.Unlock()
+	if cancel, ok := ws.runs[key]; ok {
+		cancel()
+		delete(ws.runs, key)
+	}
+}
+
+// cancelRun cancels the execution for a chat, returning false if nothing was running
+func (ws *WorkspaceBot) cancelRun(key session.Key) bool {
+	ws.runsMu.Lock()
+	defer ws.runsMu.Unlock()
+	cancel, ok := ws.runs[key]
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// isRunning reports whether a chat has a running execution
+func (ws *WorkspaceBot) isRunning(key session.Key) bool {
+	ws.runsMu.Lock()
+	defer ws.runsMu.Unlock()
+	_, ok := ws.runs[key]
+	return ok
+}
+
+// cancelAllRuns cancels the executions of all chats
+func (ws *WorkspaceBot) cancelAllRuns() {
+	ws.runsMu.Lock()
+	defer ws.runsMu.Unlock()
+	for _, cancel := range ws.runs {
+		cancel()
+	}
+}
+
+// dirLocks keeps agent runs and checkpoint restores of a directory apart:
+// any number of runs may share a directory, a restore needs it alone
+type dirLocks struct {
+	locks map[string]*sync.RWMutex
+	mu    sync.Mutex
+}
+
+// get returns the lock of a directory
+func (d *dirLocks) get(dir string) *sync.RWMutex {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.locks == nil {
+		d.locks = make(map[string]*sync.RWMutex)
+	}
+	dir = filepath.Clean(dir)
+	lock, ok := d.locks[dir]
+	if !ok {
+		lock = &sync.RWMutex{}
+		d.locks[dir] = lock
+	}
+	return lock
 }
 
 // Manager handles multiple workspace bots
 type Manager struct {
-	workspaces map[string]*WorkspaceBot
+	workspaces   map[string]*WorkspaceBot
+	retired      []*WorkspaceBot // Removed or replaced bots that may still be running prompts
+	webhook      config.WebhookConfig
+	webhookSrv   *webhookServer
+	drainTimeout time.Duration
+	customCLIs   []config.CustomCLIConfig
+	executors    map[string]executor.Executor // Shared by all workspaces, built from customCLIs
+	ledger       *usage.Ledger
+	usageStore   string
+	dirs         dirLocks // Shared by all workspaces, which may use the same directory
+	mu           sync.Mutex
+
+	// ctx is used for handling updates and running prompts; unlike the context
+	// passed to Start it is not cancelled on shutdown, so prompts can finish
+	ctx context.Context
 }
 
 // NewManager creates a new multi-bot manager
 func NewManager(cfg *config.Config) (*Manager, error) {
+	executors, err := newExecutors(cfg.CustomCLIs)
+	if err != nil {
+		return nil, err
+	}
+
+	ledger, err := usage.NewLedger(cfg.UsageStore)
+	if err != nil {
+		return nil, err
+	}
+
 	mgr := &Manager{
-		workspaces: make(map[string]*WorkspaceBot),
+		workspaces:   make(map[string]*WorkspaceBot),
+		webhook:      cfg.Webhook,
+		drainTimeout: cfg.DrainTimeout,
+		customCLIs:   cfg.CustomCLIs,
+		executors:    executors,
+		ledger:       ledger,
+		usageStore:   cfg.UsageStore,
+		ctx:          context.Background(),
 	}
 
 	for _, wsConfig := range cfg.Workspaces {
-		// Convert allowed chats to map
-		allowedChats := make(map[int64]bool)
-		for _, chatID := range wsConfig.AllowedChats {
-			allowedChats[chatID] = true
+		ws, err := newWorkspaceBot(wsConfig, executors, nil)
+		if err != nil {
+			return nil, err
 		}
+		mgr.workspaces[wsConfig.Name] = ws
+	}
 
-		// Create bot logic instance
-		botLogic := NewBot(allowedChats, wsConfig.DefaultCLI, wsConfig.Model)
+	return mgr, nil
+}
 
-		// Create Telegram bot
-		var botOpts []telego.BotOption
-		tgBot, err := telego.NewBot(wsConfig.BotToken, botOpts...)
+// newWorkspaceBot creates the bot of a workspace. If sessionMgr is nil,
+// sessions are loaded from the workspace's session store.
+func newWorkspaceBot(wsConfig config.WorkspaceConfig, executors map[string]executor.Executor, sessionMgr *session.Manager) (*WorkspaceBot, error) {
+	// Load persisted sessions and chat settings
+	if sessionMgr == nil {
+		var store session.Store
+		if wsConfig.SessionStore != "" {
+			store = session.NewFileStore(wsConfig.SessionStore)
+		}
+		var err error
+		sessionMgr, err = session.NewManager(store)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create bot for workspace %s: %w", wsConfig.Name, err)
+			return nil, fmt.Errorf("failed to load sessions for workspace %s: %w", wsConfig.Name, err)
 		}
+	}
 
-		// Store workspace bot
-		mgr.workspaces[wsConfig.Name] = &WorkspaceBot{
-			Config: wsConfig,
-			Bot:    botLogic,
-			TgBot:  tgBot,
-		}
+	// Create bot logic instance
+	botLogic := NewBot(sessionMgr, newAccess(wsConfig), executors, wsConfig.DefaultCLI, wsConfig.Model, wsConfig.DefaultModels, wsConfig.Models)
+
+	// Create Telegram bot
+	var botOpts []telego.BotOption
+	tgBot, err := telego.NewBot(wsConfig.BotToken, botOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bot for workspace %s: %w", wsConfig.Name, err)
 	}
 
-	return mgr, nil
+	return &WorkspaceBot{
+		Bot:       botLogic,
+		TgBot:     tgBot,
+		config:    wsConfig,
+		scheduler: newScheduler(wsConfig.MaxConcurrentRuns, wsConfig.MaxQueueSize),
+		albums:    mediaGroups{groups: make(map[string]*mediaGroup)},
+		voice:     transcripts{pending: make(map[transcriptKey]pendingTranscript)},
+		commits:   commitRequests{pending: make(map[commitKey]*pendingCommit), edits: make(map[commitKey]commitKey)},
+		runs:      make(map[session.Key]context.CancelFunc),
+	}, nil
 }
 
-// Start starts all workspace bots
+// Start starts all workspace bots. They stop receiving updates when ctx is done;
+// call Shutdown afterwards to wait for running prompts.
 func (m *Manager) Start(ctx context.Context) error {
-	for name, ws := range m.workspaces {
-		fmt.Printf("🤖 Starting bot for workspace: %s (dir: %s)\n", name, ws.Config.WorkingDir)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.ctx = context.WithoutCancel(ctx)
+
+	for _, ws := range m.workspaces {
+		m.startWorkspace(ctx, ws)
+	}
+	return nil
+}
+
+// Reload applies a changed configuration. Bots of new workspaces are started,
+// bots of removed workspaces stop receiving updates while their running prompts
+// finish, and the settings of the other workspaces are updated in place.
+func (m *Manager) Reload(ctx context.Context, cfg *config.Config) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if cfg.Webhook != m.webhook {
+		fmt.Println("⚠️ Webhook server settings changed, restart telecode to apply them")
+	}
+	if cfg.UsageStore != m.usageStore {
+		fmt.Println("⚠️ usage_store changed, restart telecode to apply it")
+	}
+	m.drainTimeout = cfg.DrainTimeout
 
-		// Start this workspace's bot in a goroutine
-		go func(ws *WorkspaceBot) {
-			if err := m.runWorkspaceBot(ctx, ws); err != nil {
-				fmt.Printf("❌ Bot error for workspace %s: %v\n", ws.Config.Name, err)
+	var errs []error
+	if !reflect.DeepEqual(cfg.CustomCLIs, m.customCLIs) {
+		if executors, err := newExecutors(cfg.CustomCLIs); err != nil {
+			errs = append(errs, fmt.Errorf("keeping the current custom CLIs: %w", err))
+		} else {
+			fmt.Println("🔄 Updating custom CLIs")
+			m.customCLIs = cfg.CustomCLIs
+			m.executors = executors
+		}
+	}
+
+	// Forget retired bots once their last prompt has finished
+	retired := m.retired[:0]
+	for _, ws := range m.retired {
+		if !ws.scheduler.Idle() {
+			retired = append(retired, ws)
+		}
+	}
+	m.retired = retired
+
+	names := make(map[string]bool)
+	for _, wsConfig := range cfg.Workspaces {
+		names[wsConfig.Name] = true
+
+		ws, ok := m.workspaces[wsConfig.Name]
+		if !ok {
+			ws, err := newWorkspaceBot(wsConfig, m.executors, nil)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			fmt.Printf("➕ Adding workspace: %s\n", wsConfig.Name)
+			m.workspaces[wsConfig.Name] = ws
+			m.startWorkspace(ctx, ws)
+			continue
+		}
+
+		ws.Bot.SetExecutors(m.executors)
+
+		current := ws.Config()
+		if reflect.DeepEqual(current, wsConfig) {
+			continue
+		}
+
+		// A new token is a different bot and a new store needs its sessions loaded:
+		// replace the workspace bot, letting the old one finish its running prompts
+		if current.BotToken != wsConfig.BotToken || current.SessionStore != wsConfig.SessionStore {
+			var sessionMgr *session.Manager
+			if current.SessionStore == wsConfig.SessionStore {
+				sessionMgr = ws.Bot.sessionMgr
 			}
-		}(ws)
+			replacement, err := newWorkspaceBot(wsConfig, m.executors, sessionMgr)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			fmt.Printf("🔁 Replacing bot of workspace: %s\n", wsConfig.Name)
+			m.stopWorkspace(ws)
+			m.retired = append(m.retired, ws)
+			m.workspaces[wsConfig.Name] = replacement
+			m.startWorkspace(ctx, replacement)
+			continue
+		}
+
+		fmt.Printf("🔄 Updating workspace: %s\n", wsConfig.Name)
+		ws.setConfig(wsConfig)
+		ws.Bot.Reconfigure(newAccess(wsConfig), wsConfig.DefaultCLI, wsConfig.Model, wsConfig.DefaultModels, wsConfig.Models)
+		ws.scheduler.SetLimits(wsConfig.MaxConcurrentRuns, wsConfig.MaxQueueSize)
+
+		if current.Updates != wsConfig.Updates || current.WebhookSecret != wsConfig.WebhookSecret {
+			m.stopWorkspace(ws)
+			m.startWorkspace(ctx, ws)
+		}
 	}
 
-	return nil
+	for name, ws := range m.workspaces {
+		if names[name] {
+			continue
+		}
+		fmt.Printf("➖ Removing workspace: %s\n", name)
+		m.stopWorkspace(ws)
+		m.retired = append(m.retired, ws)
+		delete(m.workspaces, name)
+	}
+
+	return errors.Join(errs...)
 }
 
-// runWorkspaceBot runs a single workspace bot
-func (m *Manager) runWorkspaceBot(ctx context.Context, ws *WorkspaceBot) error {
+// startWorkspace starts receiving and handling the updates of a workspace bot.
+// Caller must hold m.mu.
+func (m *Manager) startWorkspace(ctx context.Context, ws *WorkspaceBot) {
+	cfg := ws.Config()
+	fmt.Printf("🤖 Starting bot for workspace: %s (dir: %s, updates: %s)\n", cfg.Name, cfg.WorkingDir, cfg.Updates)
+
+	// The bot's own user is fetched once; without it every group message is answered
+	if ws.me == nil {
+		if me, err := ws.TgBot.GetMe(ctx); err != nil {
+			fmt.Printf("❌ Failed to get bot info for workspace %s: %v\n", cfg.Name, err)
+		} else {
+			ws.me = me
+		}
+	}
+
+	// Updates stop with their own context; running prompts use m.ctx
+	// and are not interrupted when a bot is stopped
+	updatesCtx, cancel := context.WithCancel(ctx)
+
 	// Get updates
+	var updates <-chan telego.Update
+	var err error
+	if cfg.Updates == "webhook" {
+		updates, err = m.webhookServer(ctx).register(updatesCtx, ws)
+	} else {
+		updates, err = startLongPolling(updatesCtx, ws)
+	}
+	if err != nil {
+		cancel()
+		fmt.Printf("❌ Bot error for workspace %s: %v\n", cfg.Name, err)
+		return
+	}
+
+	// Start this workspace's bot in a goroutine
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.runWorkspaceBot(m.ctx, ws, updates)
+	}()
+
+	ws.stopUpdates = func() {
+		if cfg.Updates == "webhook" {
+			m.webhookSrv.unregister(m.ctx, ws)
+		}
+		cancel()
+		<-done
+	}
+}
+
+// stopWorkspace stops receiving the updates of a workspace bot; queued and
+// running prompts still finish. Caller must hold m.mu.
+func (m *Manager) stopWorkspace(ws *WorkspaceBot) {
+	if ws.stopUpdates != nil {
+		ws.stopUpdates()
+		ws.stopUpdates = nil
+	}
+}
+
+// webhookServer returns the shared webhook server, starting it on first use.
+// Caller must hold m.mu.
+func (m *Manager) webhookServer(ctx context.Context) *webhookServer {
+	if m.webhookSrv != nil {
+		return m.webhookSrv
+	}
+
+	m.webhookSrv = newWebhookServer(m.webhook)
+	fmt.Printf("🌐 Listening for webhooks on %s\n", m.webhook.Listen)
+	go func(srv *webhookServer) {
+		if err := srv.run(ctx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Printf("❌ Webhook server error: %v\n", err)
+		}
+	}(m.webhookSrv)
+	return m.webhookSrv
+}
+
+// startLongPolling starts receiving updates of a workspace bot via long polling
+func startLongPolling(ctx context.Context, ws *WorkspaceBot) (<-chan telego.Update, error) {
+	// getUpdates fails while a webhook is set, e.g. after switching back from webhook mode
+	_ = ws.TgBot.DeleteWebhook(ctx, nil)
+
 	updates, err := ws.TgBot.UpdatesViaLongPolling(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("failed to start long polling: %w", err)
+		return nil, fmt.Errorf("failed to start long polling: %w", err)
 	}
+	return updates, nil
+}
 
+// runWorkspaceBot processes the updates of a single workspace bot until
+// the update channel is closed
+func (m *Manager) runWorkspaceBot(ctx context.Context, ws *WorkspaceBot, updates <-chan telego.Update) {
 	// Process updates
-	for {
-		select {
-		case <-ctx.Done():
-			return nil
-		case update, ok := <-updates:
-			if !ok {
-				return nil
-			}
-			if err := m.handleUpdate(ctx, ws, update); err != nil {
-				fmt.Printf("❌ Error handling update for %s: %v\n", ws.Config.Name, err)
-			}
+	for update := range updates {
+		// Prompts are handed off to the scheduler, so updates are handled
+		// in order without a long run blocking other chats or /cancel
+		if err := m.handleUpdate(ctx, ws, update); err != nil {
+			fmt.Printf("❌ Error handling update for %s: %v\n", ws.Config().Name, err)
 		}
 	}
 }
 
 // handleUpdate handles a single update for a workspace bot
 func (m *Manager) handleUpdate(ctx context.Context, ws *WorkspaceBot, update telego.Update) error {
+	if update.CallbackQuery != nil {
+		return m.handleCallback(ctx, ws, update.CallbackQuery)
+	}
+
 	if update.Message == nil {
 		return nil
 	}
 
-	chatID := update.Message.Chat.ID
+	key := keyFor(update.Message)
+
+	// Check if the sender may use the bot in this chat
+	var userID int64
+	if update.Message.From != nil {
+		userID = update.Message.From.ID
+	}
+	r := ws.Bot.Role(key.ChatID, userID)
+	if r == roleNone {
+		return nil
+	}
 
-	// Check if chat is allowed
-	if !ws.Bot.IsAllowed(chatID) {
+	// In groups, skip commands for other bots and, if configured, messages
+	// that do not mention or reply to this bot
+	cmd, target := getCommandFromMessage(update.Message.Text)
+	if !ws.isForMe(target) || (cmd == "" && !ws.addressed(update.Message)) {
 		return nil
 	}
 
 	// Check if message has photo
 	if len(update.Message.Photo) > 0 {
-		return m.handlePhotoMessage(ctx, ws, update.Message)
+		return m.handlePhotoMessage(ctx, ws, update.Message, r)
+	}
+
+	// Check if message is a voice note or audio file
+	// Transcription can take a while, so it runs outside the update loop;
+	// the scheduler tracks it so that shutdown and reload wait for it
+	if update.Message.Voice != nil || update.Message.Audio != nil {
+		message := update.Message
+		if !ws.scheduler.Go(func() {
+			if err := m.handleVoiceMessage(ctx, ws, message); err != nil {
+				fmt.Printf("❌ Error handling voice message for %s: %v\n", ws.Config().Name, err)
+			}
+		}) {
+			fmt.Printf("🛑 Ignored voice message for %s: the bot is stopping\n", ws.Config().Name)
+		}
+		return nil
+	}
+
+	// Check if message has a document (PDF, log, patch, uncompressed image)
+	if update.Message.Document != nil {
+		return m.handleDocumentMessage(ctx, ws, update.Message, r)
+	}
+
+	// A reply to the prompt for a new commit message
+	if cmd == "" && r >= commandRoles["/commit"] {
+		if handled, err := m.handleCommitEdit(ctx, ws, key, update.Message); handled {
+			return err
+		}
 	}
 
 	// Get command handler
-	cmd := getCommandFromMessage(update.Message.Text)
+	if need, ok := commandRoles[cmd]; ok && r < need {
+		return m.denyCommand(ctx, ws, key, cmd, need)
+	}
 
 	switch cmd {
 	case "/new":
-		return m.handleNewSession(ctx, ws, chatID)
+		return m.handleNewSession(ctx, ws, key, update.Message.Text)
+	case "/sessions":
+		return m.handleSessions(ctx, ws, key)
+	case "/switch":
+		return m.handleSwitch(ctx, ws, key, update.Message.Text)
+	case "/resume":
+		return m.handleResume(ctx, ws, key, update.Message.Text)
 	case "/status":
-		return m.handleStatus(ctx, ws, chatID)
+		return m.handleStatus(ctx, ws, key, r)
 	case "/cli":
-		return m.handleCLI(ctx, ws, chatID, update.Message.Text)
+		return m.handleCLI(ctx, ws, key, update.Message.Text)
+	case "/model":
+		return m.handleModel(ctx, ws, key, update.Message.Text)
+	case "/budget":
+		return m.handleBudget(ctx, ws, key, userID, r, update.Message.Text)
 	case "/stats":
-		return m.handleStats(ctx, ws, chatID)
+		return m.handleStats(ctx, ws, key, r, update.Message.Text)
+	case "/diff":
+		return m.handleDiff(ctx, ws, key, update.Message.Text)
+	case "/log":
+		return m.handleLog(ctx, ws, key, update.Message.Text)
+	case "/gitstatus":
+		return m.handleGitStatus(ctx, ws, key)
+	case "/show":
+		return m.handleShow(ctx, ws, key, update.Message.Text)
+	case "/commit":
+		return m.handleCommit(ctx, ws, key, update.Message.Text)
+	case "/checkpoints":
+		return m.handleCheckpoints(ctx, ws, key)
+	case "/undo":
+		return m.handleUndo(ctx, ws, key)
+	case "/restore":
+		return m.handleRestore(ctx, ws, key, update.Message.Text)
+	case "/worktrees":
+		return m.handleWorktrees(ctx, ws, key)
+	case "/merge":
+		return m.handleMerge(ctx, ws, key, update.Message.Text)
+	case "/discard":
+		return m.handleDiscard(ctx, ws, key, update.Message.Text)
+	case "/cancel":
+		return m.handleCancel(ctx, ws, key)
+	case "/queue":
+		return m.handleQueue(ctx, ws, key, update.Message.Text)
 	default:
 		// Handle regular message
-		return m.handleMessage(ctx, ws, chatID, update.Message.Text, "")
+		return m.handleMessage(ctx, ws, key, update.Message.From, r, ws.stripMention(update.Message.Text), nil, nil)
+	}
+}
+
+// handleCallback handles inline keyboard button presses
+func (m *Manager) handleCallback(ctx context.Context, ws *WorkspaceBot, query *telego.CallbackQuery) error {
+	// Always answer so the button stops showing a spinner
+	defer func() { _ = ws.TgBot.AnswerCallbackQuery(ctx, tu.CallbackQuery(query.ID)) }()
+
+	if query.Message == nil {
+		return nil
 	}
+	key := callbackKey(query)
+
+	// Check if the user may use the bot in this chat
+	r := ws.Bot.Role(key.ChatID, query.From.ID)
+	if r == roleNone {
+		return nil
+	}
+
+	switch {
+	case strings.HasPrefix(query.Data, callbackSwitchPrefix):
+		if need := commandRoles["/switch"]; r < need {
+			return m.denyCommand(ctx, ws, key, "/switch", need)
+		}
+		return m.switchSession(ctx, ws, key, strings.TrimPrefix(query.Data, callbackSwitchPrefix))
+	case strings.HasPrefix(query.Data, callbackModelPrefix):
+		if need := commandRoles["/model"]; r < need {
+			return m.denyCommand(ctx, ws, key, "/model", need)
+		}
+		return m.setModel(ctx, ws, key, strings.TrimPrefix(query.Data, callbackModelPrefix))
+	case strings.HasPrefix(query.Data, callbackDiscardPrefix):
+		if need := commandRoles["/discard"]; r < need {
+			return m.denyCommand(ctx, ws, key, "/discard", need)
+		}
+		return m.discardWorktree(ctx, ws, query)
+	case strings.HasPrefix(query.Data, callbackCommitPrefix):
+		if need := commandRoles["/commit"]; r < need {
+			return m.denyCommand(ctx, ws, key, "/commit", need)
+		}
+		return m.handleCommitCallback(ctx, ws, query)
+	case strings.HasPrefix(query.Data, callbackVoicePrefix):
+		return m.handleVoiceCallback(ctx, ws, query, r)
+	}
+	return nil
 }
 
-func getCommandFromMessage(text string) string {
+// getCommandFromMessage returns the command of a message and, for commands
+// like "/new@my_bot" used in groups, the bot it is addressed to
+func getCommandFromMessage(text string) (cmd, target string) {
 	if len(text) == 0 {
-		return ""
+		return "", ""
 	}
 	if text[0] == '/' {
-		// Extract command (up to first space or end)
+		// Extract command (up to first whitespace or end)
+		cmd = text
 		for i, c := range text {
-			if c == ' ' {
-				return text[:i]
+			if c == ' ' || c == '\n' {
+				cmd = text[:i]
+				break
 			}
 		}
-		return text
+		cmd, target, _ = strings.Cut(cmd, "@")
+		return cmd, target
 	}
-	return ""
+	return "", ""
+}
+
+// keyFor returns the conversation a message belongs to: its chat, or its
+// forum topic in supergroups with topics enabled
+func keyFor(message *telego.Message) session.Key {
+	key := session.Key{ChatID: message.Chat.ID}
+	// Replies in groups without topics carry a thread ID too; only topics are separate conversations
+	if message.IsTopicMessage {
+		key.ThreadID = message.MessageThreadID
+	}
+	return key
+}
+
+// callbackKey returns the conversation of the message a button belongs to
+func callbackKey(query *telego.CallbackQuery) session.Key {
+	if message, ok := query.Message.(*telego.Message); ok {
+		return keyFor(message)
+	}
+	return session.Key{ChatID: query.Message.GetChat().ID}
+}
+
+// chatMessage creates a message to the chat and forum topic of key
+func chatMessage(key session.Key, text string) *telego.SendMessageParams {
+	return tu.Message(tu.ID(key.ChatID), text).WithMessageThreadID(key.ThreadID)
 }
@@ -0,0 +1,314 @@
+package bot
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxLanguageLength is the longest code block language hint kept; longer
+// fence headers are not language names
+const maxLanguageLength = 32
+
+var (
+	headingRegex = regexp.MustCompile(`^#{1,6}\s+(.*?)\s*#*$`)
+	listRegex    = regexp.MustCompile(`^(\s*)[-*+]\s+(.*)$`)
+	ruleRegex    = regexp.MustCompile(`^\s*([-*_])(\s*[-*_]){2,}\s*$`)
+)
+
+// markdownToHTML converts CommonMark (as written by coding agents) into the
+// HTML subset supported by Telegram. Inline formatting never spans lines,
+// so any line boundary is a safe place to split the output.
+func markdownToHTML(md string) string {
+	var blocks []string
+	var quote []string
+	var code []string
+	inFence := false
+	lang := ""
+
+	flushQuote := func() {
+		if len(quote) > 0 {
+			blocks = append(blocks, "<blockquote>"+strings.Join(quote, "\n")+"</blockquote>")
+			quote = nil
+		}
+	}
+
+	for _, line := range strings.Split(md, "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		if inFence {
+			if strings.HasPrefix(trimmed, "```") {
+				blocks = append(blocks, codeBlockHTML(lang, code))
+				inFence, code = false, nil
+				continue
+			}
+			code = append(code, line)
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "```") {
+			flushQuote()
+			inFence = true
+			lang = strings.TrimSpace(strings.TrimPrefix(trimmed, "```"))
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, ">") {
+			quote = append(quote, renderInline(strings.TrimSpace(strings.TrimPrefix(trimmed, ">"))))
+			continue
+		}
+		flushQuote()
+
+		switch {
+		case ruleRegex.MatchString(line):
+			blocks = append(blocks, "——————")
+		case headingRegex.MatchString(line):
+			blocks = append(blocks, "<b>"+renderInline(headingRegex.FindStringSubmatch(line)[1])+"</b>")
+		case listRegex.MatchString(line):
+			match := listRegex.FindStringSubmatch(line)
+			blocks = append(blocks, match[1]+"• "+renderInline(match[2]))
+		default:
+			blocks = append(blocks, renderInline(line))
+		}
+	}
+
+	flushQuote()
+	if inFence {
+		// Unterminated fence: render what we have
+		blocks = append(blocks, codeBlockHTML(lang, code))
+	}
+
+	return strings.Join(blocks, "\n")
+}
+
+// codeBlockHTML renders a fenced code block, keeping the language hint
+func codeBlockHTML(lang string, lines []string) string {
+	content := html.EscapeString(strings.Join(lines, "\n"))
+	if fields := strings.Fields(lang); len(fields) > 0 && len(fields[0]) <= maxLanguageLength {
+		return `<pre><code class="language-` + html.EscapeString(fields[0]) + `">` + content + "</code></pre>"
+	}
+	return "<pre>" + content + "</pre>"
+}
+
+// renderInline converts inline Markdown (code, links, bold, italic, strikethrough) to HTML
+func renderInline(s string) string {
+	var out strings.Builder
+
+	for i := 0; i < len(s); {
+		c := s[i]
+
+		switch {
+		case c == '\\' && i+1 < len(s) && strings.IndexByte("\\`*_~[]()#+-.!>", s[i+1]) >= 0:
+			// Escaped punctuation
+			out.WriteString(html.EscapeString(s[i+1 : i+2]))
+			i += 2
+			continue
+
+		case c == '`':
+			// Code span: closed by a backtick run of the same length
+			run := countRun(s[i:], '`')
+			delim := s[i : i+run]
+			if end := strings.Index(s[i+run:], delim); end >= 0 {
+				inner := strings.TrimSpace(s[i+run : i+run+end])
+				out.WriteString("<code>" + html.EscapeString(inner) + "</code>")
+				i += run + end + run
+				continue
+			}
+			out.WriteString(html.EscapeString(delim))
+			i += run
+			continue
+
+		case c == '[':
+			if text, url, n, ok := parseLink(s[i:]); ok {
+				out.WriteString(`<a href="` + html.EscapeString(url) + `">` + renderInline(text) + "</a>")
+				i += n
+				continue
+			}
+
+		case c == '*' || c == '_' || c == '~':
+			if tag, inner, n, ok := parseEmphasis(s, i); ok {
+				out.WriteString("<" + tag + ">" + renderInline(inner) + "</" + tag + ">")
+				i += n
+				continue
+			}
+		}
+
+		out.WriteString(html.EscapeString(s[i : i+1]))
+		i++
+	}
+
+	return out.String()
+}
+
+// parseLink parses [text](url) at the start of s
+func parseLink(s string) (text, url string, n int, ok bool) {
+	closeText := strings.Index(s, "](")
+	if closeText < 1 {
+		return "", "", 0, false
+	}
+	closeURL := strings.IndexByte(s[closeText+2:], ')')
+	if closeURL < 0 {
+		return "", "", 0, false
+	}
+	url = s[closeText+2 : closeText+2+closeURL]
+	if strings.ContainsAny(url, " \t") || url == "" {
+		return "", "", 0, false
+	}
+	return s[1:closeText], url, closeText + 2 + closeURL + 1, true
+}
+
+// parseEmphasis parses **bold**, __bold__, *italic*, _italic_ or ~~strike~~ starting at s[i]
+func parseEmphasis(s string, i int) (tag, inner string, n int, ok bool) {
+	c := s[i]
+	run := countRun(s[i:], c)
+
+	var delim string
+	switch {
+	case c == '~' && run >= 2:
+		delim, tag = "~~", "s"
+	case c != '~' && run >= 2:
+		delim, tag = s[i:i+2], "b"
+	case c != '~' && run == 1:
+		delim, tag = s[i:i+1], "i"
+	default:
+		return "", "", 0, false
+	}
+
+	start := i + len(delim)
+	// Opening delimiter must be followed by a non-space character
+	if start >= len(s) || s[start] == ' ' {
+		return "", "", 0, false
+	}
+	// Underscores inside words (snake_case, working_dir) are literal
+	if c == '_' && i > 0 && isWordChar(s[i-1]) {
+		return "", "", 0, false
+	}
+
+	for j := start + 1; j+len(delim) <= len(s); j++ {
+		if s[j:j+len(delim)] != delim || s[j-1] == ' ' {
+			continue
+		}
+		end := j + len(delim)
+		if c == '_' && end < len(s) && isWordChar(s[end]) {
+			continue
+		}
+		// A single delimiter must not be part of a longer run
+		if len(delim) == 1 && end < len(s) && s[end] == c {
+			j++
+			continue
+		}
+		return tag, s[start:j], end - i, true
+	}
+	return "", "", 0, false
+}
+
+// countRun returns how many times c repeats at the start of s
+func countRun(s string, c byte) int {
+	n := 0
+	for n < len(s) && s[n] == c {
+		n++
+	}
+	return n
+}
+
+// isWordChar reports whether b is an ASCII letter or digit
+func isWordChar(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9'
+}
+
+// chunkMarkdown splits Markdown into chunks of at most size runes at line
+// boundaries. A code fence that spans a cut is closed at the end of the
+// chunk and reopened with the same language at the start of the next one.
+func chunkMarkdown(md string, size int) []string {
+	const fenceClose = "\n```"
+
+	var chunks []string
+	var current strings.Builder
+	currentLen := 0
+	fence := "" // Opening fence line while inside a code block
+
+	flush := func() {
+		text := current.String()
+		if fence != "" {
+			text += fenceClose
+		}
+		chunks = append(chunks, text)
+		current.Reset()
+		currentLen = 0
+		if fence != "" {
+			current.WriteString(fence)
+			currentLen = len([]rune(fence))
+		}
+	}
+
+	for _, line := range strings.Split(md, "\n") {
+		reserve := 0
+		if fence != "" {
+			reserve = len([]rune(fenceClose))
+		}
+
+		// Lines longer than a chunk are split by length. A fence line
+		// longer than a chunk leaves no room, so pieces get a full chunk.
+		width := size - reserve - len([]rune(fence)) - 1
+		if width <= 0 {
+			width = size
+		}
+		for _, piece := range chunkString(line, width) {
+			pieceLen := len([]rune(piece))
+			if currentLen > 0 && currentLen+1+pieceLen+reserve > size {
+				flush()
+			}
+			if currentLen > 0 {
+				current.WriteString("\n")
+				currentLen++
+			}
+			current.WriteString(piece)
+			currentLen += pieceLen
+		}
+
+		if trimmed := strings.TrimSpace(line); strings.HasPrefix(trimmed, "```") {
+			if fence == "" {
+				fence = trimmed
+			} else {
+				fence = ""
+			}
+		}
+	}
+
+	if currentLen > 0 {
+		fence = "" // Unterminated fences are closed by markdownToHTML
+		chunks = append(chunks, current.String())
+	}
+	return chunks
+}
+
+// htmlChunk is a chunk of Markdown with its HTML rendering
+type htmlChunk struct {
+	markdown string
+	html     string
+}
+
+// htmlChunks renders Markdown as Telegram HTML messages of at most limit
+// runes. Escaping and tags make the HTML longer than the Markdown, so a chunk
+// that grew past the limit is split again with a proportionally smaller size.
+func htmlChunks(md string, limit int) []htmlChunk {
+	return renderChunks(md, limit, limit)
+}
+
+// renderChunks splits md into chunks of at most size runes and renders them,
+// splitting chunks whose HTML is longer than limit again
+func renderChunks(md string, size, limit int) []htmlChunk {
+	var chunks []htmlChunk
+	for _, chunk := range chunkMarkdown(md, size) {
+		rendered := markdownToHTML(chunk)
+		// Escaping grows a rune at most fivefold, so the size never needs
+		// to shrink below limit/8
+		if n := utf8.RuneCountInString(rendered); n > limit && size*limit/n >= limit/8 {
+			chunks = append(chunks, renderChunks(chunk, size*limit/n, limit)...)
+			continue
+		}
+		chunks = append(chunks, htmlChunk{markdown: chunk, html: rendered})
+	}
+	return chunks
+}
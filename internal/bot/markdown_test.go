@@ -0,0 +1,49 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestHTMLChunksLimit(t *testing.T) {
+	const limit = 4096
+	code := strings.Repeat("if a < b && c > d { x = \"<tag>\" }\n", 400)
+	tests := []struct {
+		name  string
+		md    string
+		count string // Rendered text that must be kept
+		want  int
+	}{
+		{"short fence", "```go\n" + code + "```", "&lt;tag&gt;", 400},
+		{"fence leaving one rune", "```" + strings.Repeat("x", limit-6) + "\n" + code + "```", "&lt;tag&gt;", 400},
+		{"fence leaving no room", "```" + strings.Repeat("x", limit-5) + "\n" + code + "```", "&lt;tag&gt;", 400},
+		{"fence longer than a chunk", "```" + strings.Repeat("x", 2*limit) + "\n" + code + "```", "&lt;tag&gt;", 400},
+		{"escaped text outside code", strings.Repeat("a & b < c > d\n", 1000), "&amp;", 1000},
+		{"long line of escapes", strings.Repeat("<&>", 5000), "&amp;", 5000},
+		{"formatting", strings.Repeat("**bold** and `code` with [link](https://example.com/a?b=1&c=2)\n", 300), "<b>bold</b>", 300},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := htmlChunks(tt.md, limit)
+			got := 0
+			for i, chunk := range chunks {
+				if n := utf8.RuneCountInString(chunk.html); n > limit {
+					t.Errorf("chunk %d has %d runes, want at most %d", i, n, limit)
+				}
+				got += strings.Count(chunk.html, tt.count)
+			}
+			if got != tt.want {
+				t.Errorf("chunks hold %d of %q, want %d", got, tt.count, tt.want)
+			}
+		})
+	}
+}
+
+func TestChunkStringNonPositiveSize(t *testing.T) {
+	for _, size := range []int{0, -5} {
+		if chunks := chunkString("abc", size); strings.Join(chunks, "") != "abc" {
+			t.Errorf("chunkString(%q, %d) = %q", "abc", size, chunks)
+		}
+	}
+}
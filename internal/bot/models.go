@@ -0,0 +1,93 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"slices"
+	"strings"
+
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+	"telecode/internal/session"
+)
+
+const (
+	// callbackModelPrefix prefixes callback data of the /model buttons
+	callbackModelPrefix = "model:"
+
+	// modelDefault selects the default model of the CLI again
+	modelDefault = "default"
+)
+
+// handleModel handles the /model command
+func (m *Manager) handleModel(ctx context.Context, ws *WorkspaceBot, key session.Key, text string) error {
+	if args := strings.Fields(text); len(args) > 1 {
+		return m.setModel(ctx, ws, key, args[1])
+	}
+
+	cli := ws.Bot.GetCLI(key)
+	current := ws.Bot.GetModel(key)
+	reply := fmt.Sprintf("🧠 Current model: <code>%s</code> (CLI: <code>%s</code>)",
+		html.EscapeString(modelName(current)), html.EscapeString(cli))
+
+	models := ws.Bot.Models(key)
+	if len(models) == 0 {
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			reply+"\n\nNo other models are configured for this CLI.",
+		).WithParseMode(telego.ModeHTML))
+		return err
+	}
+
+	// Callback data is limited to 64 bytes; longer names can still be typed
+	var buttons []telego.InlineKeyboardButton
+	for _, model := range slices.Concat(models, []string{modelDefault}) {
+		if len(callbackModelPrefix+model) > 64 {
+			continue
+		}
+		label := model
+		if model == current {
+			label = "✅ " + model
+		}
+		buttons = append(buttons, tu.InlineKeyboardButton(label).WithCallbackData(callbackModelPrefix+model))
+	}
+
+	_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+		key,
+		reply+"\n\nChoose a model or use /model &lt;name&gt;:",
+	).WithParseMode(telego.ModeHTML).WithReplyMarkup(tu.InlineKeyboardGrid(tu.InlineKeyboardCols(2, buttons...))))
+	return err
+}
+
+// setModel changes the model of a chat and reports the result
+func (m *Manager) setModel(ctx context.Context, ws *WorkspaceBot, key session.Key, model string) error {
+	if model == modelDefault {
+		model = ""
+	}
+	if err := ws.Bot.SetModel(key, model); err != nil {
+		text := fmt.Sprintf("❌ %v", err)
+		if models := ws.Bot.Models(key); len(models) > 0 {
+			text += ". Use: " + strings.Join(models, " | ")
+		}
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			text,
+		))
+		return err
+	}
+
+	_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+		key,
+		fmt.Sprintf("✅ Model changed to: <code>%s</code>", html.EscapeString(modelName(ws.Bot.GetModel(key)))),
+	).WithParseMode(telego.ModeHTML))
+	return err
+}
+
+// modelName returns a model for display, naming the CLI's own default
+func modelName(model string) string {
+	if model == "" {
+		return "CLI default"
+	}
+	return model
+}
@@ -0,0 +1,26 @@
+//go:build windows
+
+package bot
+
+import (
+	"os/exec"
+)
+
+// setProcessGroup is a no-op on Windows
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// interruptProcessGroup kills the process; Windows has no SIGINT for child processes
+func interruptProcessGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return cmd.Process.Kill()
+}
+
+// killProcessGroup kills the process
+func killProcessGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return cmd.Process.Kill()
+}
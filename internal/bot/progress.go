@@ -0,0 +1,134 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+	"telecode/internal/session"
+)
+
+const (
+	// progressEditInterval throttles edits of the progress message.
+	// Telegram allows roughly one edit per second per chat, so stay well below that.
+	progressEditInterval = 3 * time.Second
+
+	// progressMaxLength is the number of trailing runes shown in the progress message
+	progressMaxLength = 3500
+)
+
+// progressMessage is a single Telegram message that is edited in place
+// to show the live output of a running agent
+type progressMessage struct {
+	bot       *telego.Bot
+	key       session.Key
+	messageID int
+	started   time.Time
+
+	// format turns the raw output collected so far into displayable text
+	format func(raw string) string
+
+	mu       sync.Mutex
+	raw      strings.Builder
+	lastText string
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// startProgress sends the initial progress message and starts the edit loop.
+// It returns nil if the message could not be sent; all methods are nil-safe.
+func startProgress(ctx context.Context, bot *telego.Bot, key session.Key, format func(string) string) *progressMessage {
+	msg, err := bot.SendMessage(ctx, chatMessage(key, "⏳ Working..."))
+	if err != nil {
+		return nil
+	}
+
+	p := &progressMessage{
+		bot:       bot,
+		key:       key,
+		messageID: msg.MessageID,
+		started:   time.Now(),
+		format:    format,
+		done:      make(chan struct{}),
+	}
+
+	p.wg.Add(1)
+	go p.loop(ctx)
+	return p
+}
+
+// Append adds a line of raw output
+func (p *progressMessage) Append(line string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.raw.WriteString(line)
+}
+
+// loop periodically flushes new output to Telegram
+func (p *progressMessage) loop(ctx context.Context) {
+	defer p.wg.Done()
+	ticker := time.NewTicker(progressEditInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.done:
+			return
+		case <-ticker.C:
+			p.flush(ctx)
+		}
+	}
+}
+
+// flush edits the message if the rendered output changed since the last edit
+func (p *progressMessage) flush(ctx context.Context) {
+	p.mu.Lock()
+	raw := p.raw.String()
+	p.mu.Unlock()
+
+	body := strings.TrimSpace(raw)
+	if p.format != nil {
+		body = strings.TrimSpace(p.format(raw))
+	}
+	if body == "" {
+		return
+	}
+
+	text := "⏳ Working... (" + time.Since(p.started).Round(time.Second).String() + ")\n\n" + tailRunes(body, progressMaxLength)
+	if text == p.lastText {
+		return
+	}
+
+	// Best effort: a failed edit (e.g. rate limited) is retried on the next tick
+	if _, err := p.bot.EditMessageText(ctx, tu.EditMessageText(tu.ID(p.key.ChatID), p.messageID, text)); err == nil {
+		p.lastText = text
+	}
+}
+
+// Finish stops the edit loop and replaces the progress message with a final status line
+func (p *progressMessage) Finish(ctx context.Context, status string) {
+	if p == nil {
+		return
+	}
+	close(p.done)
+	p.wg.Wait()
+
+	text := status + " (" + time.Since(p.started).Round(time.Second).String() + ")"
+	_, _ = p.bot.EditMessageText(ctx, tu.EditMessageText(tu.ID(p.key.ChatID), p.messageID, text))
+}
+
+// tailRunes returns the last n runes of s, prefixed with an ellipsis if truncated
+func tailRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return "…" + string(runes[len(runes)-n:])
+}
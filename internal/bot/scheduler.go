@@ -0,0 +1,274 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"telecode/internal/session"
+	"time"
+)
+
+var (
+	// errQueueFull is returned when a chat has too many pending prompts
+	errQueueFull = errors.New("queue is full")
+
+	// errSchedulerClosed is returned when a job is submitted during shutdown
+	errSchedulerClosed = errors.New("scheduler is closed")
+)
+
+// job is a prompt waiting to be executed
+type job struct {
+	id       int
+	ctx      context.Context
+	key      session.Key
+	prompt   string
+	files    []string
+	readOnly bool // Run the agent in plan mode
+	userID   int64
+	userName string
+	queuedAt time.Time
+
+	// run executes the prompt
+	run func(ctx context.Context)
+
+	// cleanup is called once the job has run or was dropped (may be nil)
+	cleanup func()
+}
+
+// finish releases the job's resources
+func (j *job) finish() {
+	if j.cleanup != nil {
+		j.cleanup()
+	}
+}
+
+// scheduler runs jobs with one worker per chat, so that prompts of a chat
+// execute in FIFO order, and a workspace-wide limit on concurrent runs
+type scheduler struct {
+	mu       sync.Mutex
+	pending  map[session.Key][]*job
+	working  map[session.Key]bool
+	slots    chan struct{}
+	maxQueue int
+	nextID   int
+	tasks    int // Background tasks started with Go that are still running
+
+	closed chan struct{} // Closed by Close
+	idle   chan struct{} // Closed once the scheduler is closed and no worker or task is left
+}
+
+// newScheduler creates a scheduler running at most concurrency jobs at once
+// and holding at most maxQueue pending jobs per chat
+func newScheduler(concurrency, maxQueue int) *scheduler {
+	return &scheduler{
+		pending:  make(map[session.Key][]*job),
+		working:  make(map[session.Key]bool),
+		slots:    make(chan struct{}, concurrency),
+		maxQueue: maxQueue,
+		closed:   make(chan struct{}),
+		idle:     make(chan struct{}),
+	}
+}
+
+// Submit enqueues a job. It returns the job's position in the chat's queue,
+// or 0 if the chat was idle. For an idle chat, waiting reports that every
+// slot is busy, so the job only starts once another chat's run finishes.
+func (s *scheduler) Submit(j *job) (position int, waiting bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.isClosed() {
+		return 0, false, errSchedulerClosed
+	}
+
+	j.queuedAt = time.Now()
+	if !s.working[j.key] {
+		s.working[j.key] = true
+		s.nextID++
+		j.id = s.nextID
+		waiting = len(s.slots) >= cap(s.slots)
+		go s.work(j)
+		return 0, waiting, nil
+	}
+
+	if len(s.pending[j.key]) >= s.maxQueue {
+		return 0, false, errQueueFull
+	}
+	s.nextID++
+	j.id = s.nextID
+	s.pending[j.key] = append(s.pending[j.key], j)
+	return len(s.pending[j.key]), false, nil
+}
+
+// work runs j and then every job queued for the same chat until the queue is empty
+func (s *scheduler) work(j *job) {
+	for j != nil {
+		s.execute(j)
+		j = s.next(j.key)
+	}
+}
+
+// execute runs a job once a global slot is free
+func (s *scheduler) execute(j *job) {
+	defer j.finish()
+
+	// The slot is released to the semaphore it was taken from, even if
+	// SetLimits replaced it in the meantime
+	s.mu.Lock()
+	slots := s.slots
+	s.mu.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+	case <-j.ctx.Done():
+		return
+	case <-s.closed:
+		return
+	}
+	defer func() { <-slots }()
+
+	// Jobs still waiting for a slot do not start once the scheduler is closed
+	if s.isClosed() {
+		return
+	}
+
+	j.run(j.ctx)
+}
+
+// next pops the next job of a chat, marking the worker idle if there is none
+func (s *scheduler) next(key session.Key) *job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queue := s.pending[key]
+	if len(queue) == 0 {
+		delete(s.pending, key)
+		delete(s.working, key)
+		if len(s.working) == 0 && s.tasks == 0 && s.isClosed() {
+			close(s.idle)
+		}
+		return nil
+	}
+	s.pending[key] = queue[1:]
+	return queue[0]
+}
+
+// Close stops accepting jobs and drops the pending ones. It returns the chats
+// that had running or pending jobs, and a channel that is closed once the
+// running jobs have finished.
+func (s *scheduler) Close() ([]session.Key, <-chan struct{}) {
+	s.mu.Lock()
+	if s.isClosed() {
+		s.mu.Unlock()
+		return nil, s.idle
+	}
+	close(s.closed)
+
+	var chats []session.Key
+	var dropped []*job
+	for key := range s.working {
+		chats = append(chats, key)
+		dropped = append(dropped, s.pending[key]...)
+	}
+	s.pending = make(map[session.Key][]*job)
+	if len(s.working) == 0 && s.tasks == 0 {
+		close(s.idle)
+	}
+	s.mu.Unlock()
+
+	for _, j := range dropped {
+		j.finish()
+	}
+	return chats, s.idle
+}
+
+// Idle reports whether no job or task is running or pending
+func (s *scheduler) Idle() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.working) == 0 && s.tasks == 0
+}
+
+// Go runs fn in the background outside the chat queues (e.g. a transcription),
+// so that Close waits for it like for a job. It returns false without running
+// fn once the scheduler is closed.
+func (s *scheduler) Go(fn func()) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.isClosed() {
+		return false
+	}
+	s.tasks++
+	go func() {
+		defer s.taskDone()
+		fn()
+	}()
+	return true
+}
+
+// taskDone marks a task started with Go as finished
+func (s *scheduler) taskDone() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tasks--
+	if len(s.working) == 0 && s.tasks == 0 && s.isClosed() {
+		close(s.idle)
+	}
+}
+
+// isClosed reports whether Close was called
+func (s *scheduler) isClosed() bool {
+	select {
+	case <-s.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// SetLimits changes the concurrency and queue limits. Jobs already running
+// keep their slots, so the new concurrency limit applies to jobs started later.
+func (s *scheduler) SetLimits(concurrency, maxQueue int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cap(s.slots) != concurrency {
+		s.slots = make(chan struct{}, concurrency)
+	}
+	s.maxQueue = maxQueue
+}
+
+// Pending returns the jobs waiting for a chat, in execution order
+func (s *scheduler) Pending(key session.Key) []*job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*job(nil), s.pending[key]...)
+}
+
+// Drop removes the pending job at the given 1-based position
+func (s *scheduler) Drop(key session.Key, position int) (*job, bool) {
+	s.mu.Lock()
+	queue := s.pending[key]
+	if position < 1 || position > len(queue) {
+		s.mu.Unlock()
+		return nil, false
+	}
+	j := queue[position-1]
+	s.pending[key] = append(queue[:position-1:position-1], queue[position:]...)
+	s.mu.Unlock()
+
+	j.finish()
+	return j, true
+}
+
+// Clear removes all pending jobs of a chat and returns how many were dropped
+func (s *scheduler) Clear(key session.Key) int {
+	s.mu.Lock()
+	queue := s.pending[key]
+	s.pending[key] = nil
+	s.mu.Unlock()
+
+	for _, j := range queue {
+		j.finish()
+	}
+	return len(queue)
+}
@@ -0,0 +1,190 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"telecode/internal/session"
+)
+
+// testJob returns a job that records its prompt in order and blocks until release is closed
+func testJob(key session.Key, prompt string, order *[]string, mu *sync.Mutex, started chan<- string, release <-chan struct{}) *job {
+	return &job{
+		ctx:    context.Background(),
+		key:    key,
+		prompt: prompt,
+		run: func(ctx context.Context) {
+			mu.Lock()
+			*order = append(*order, prompt)
+			mu.Unlock()
+			started <- prompt
+			<-release
+		},
+	}
+}
+
+// waitStarted returns the prompt of the next job that started, failing after a timeout
+func waitStarted(t *testing.T, started <-chan string) string {
+	t.Helper()
+	select {
+	case prompt := <-started:
+		return prompt
+	case <-time.After(5 * time.Second):
+		t.Fatal("no job started")
+		return ""
+	}
+}
+
+// assertNoStart fails if a job starts within a short time
+func assertNoStart(t *testing.T, started <-chan string) {
+	t.Helper()
+	select {
+	case prompt := <-started:
+		t.Fatalf("job %q started, want it to wait", prompt)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSchedulerQueue(t *testing.T) {
+	s := newScheduler(2, 2)
+	chat := session.Key{ChatID: 1}
+	var order []string
+	var mu sync.Mutex
+	started := make(chan string, 10)
+	release := make(chan struct{})
+
+	tests := []struct {
+		prompt   string
+		position int
+		err      error
+	}{
+		{"first", 0, nil},
+		{"second", 1, nil},
+		{"third", 2, nil},
+		{"fourth", 0, errQueueFull},
+	}
+	for _, tt := range tests {
+		position, waiting, err := s.Submit(testJob(chat, tt.prompt, &order, &mu, started, release))
+		if position != tt.position || waiting || !errors.Is(err, tt.err) {
+			t.Errorf("Submit(%s) = %d, %v, %v, want %d, false, %v", tt.prompt, position, waiting, err, tt.position, tt.err)
+		}
+	}
+	if got := waitStarted(t, started); got != "first" {
+		t.Fatalf("started %q first", got)
+	}
+	assertNoStart(t, started)
+	if pending := s.Pending(chat); len(pending) != 2 || pending[0].prompt != "second" {
+		t.Errorf("Pending() has %d jobs", len(pending))
+	}
+
+	// The queued jobs of a chat run one after the other, in order
+	close(release)
+	waitStarted(t, started)
+	waitStarted(t, started)
+	_, idle := s.Close()
+	<-idle
+	if want := []string{"first", "second", "third"}; !slices.Equal(order, want) {
+		t.Errorf("order = %q, want %q", order, want)
+	}
+	if _, _, err := s.Submit(testJob(chat, "late", &order, &mu, started, release)); !errors.Is(err, errSchedulerClosed) {
+		t.Errorf("Submit after Close = %v, want %v", err, errSchedulerClosed)
+	}
+}
+
+func TestSchedulerConcurrencyLimit(t *testing.T) {
+	s := newScheduler(1, 5)
+	var order []string
+	var mu sync.Mutex
+	started := make(chan string, 10)
+	release := make(chan struct{})
+
+	if _, waiting, _ := s.Submit(testJob(session.Key{ChatID: 1}, "a", &order, &mu, started, release)); waiting {
+		t.Error("first job waits for a slot")
+	}
+	waitStarted(t, started)
+
+	// Another chat waits for the only slot
+	if _, waiting, _ := s.Submit(testJob(session.Key{ChatID: 2}, "b", &order, &mu, started, release)); !waiting {
+		t.Error("second chat's job does not report waiting for a slot")
+	}
+	assertNoStart(t, started)
+
+	// A higher limit applies to jobs started later; the waiting job keeps
+	// waiting on the old semaphore, so a third chat starts right away
+	s.SetLimits(2, 5)
+	if _, waiting, _ := s.Submit(testJob(session.Key{ChatID: 3}, "c", &order, &mu, started, release)); waiting {
+		t.Error("job after raising the limit waits for a slot")
+	}
+	if got := waitStarted(t, started); got != "c" {
+		t.Errorf("started %q, want c", got)
+	}
+
+	close(release)
+	if got := waitStarted(t, started); got != "b" {
+		t.Errorf("started %q, want b", got)
+	}
+	_, idle := s.Close()
+	<-idle
+}
+
+func TestSchedulerLowerQueueLimit(t *testing.T) {
+	s := newScheduler(1, 5)
+	chat := session.Key{ChatID: 1}
+	var order []string
+	var mu sync.Mutex
+	started := make(chan string, 10)
+	release := make(chan struct{})
+
+	s.Submit(testJob(chat, "a", &order, &mu, started, release))
+	waitStarted(t, started)
+	s.Submit(testJob(chat, "b", &order, &mu, started, release))
+	s.SetLimits(1, 1)
+	if _, _, err := s.Submit(testJob(chat, "c", &order, &mu, started, release)); !errors.Is(err, errQueueFull) {
+		t.Errorf("Submit over the lowered queue limit = %v, want %v", err, errQueueFull)
+	}
+
+	// Close drops the queued job
+	chats, idle := s.Close()
+	close(release)
+	<-idle
+	if !slices.Equal(chats, []session.Key{chat}) || !slices.Equal(order, []string{"a"}) {
+		t.Errorf("Close() = %v with order %q, want [%v] and [a]", chats, order, chat)
+	}
+}
+
+func TestSchedulerCloseWaitsForTasks(t *testing.T) {
+	s := newScheduler(1, 1)
+	release := make(chan struct{})
+	finished := make(chan struct{})
+	if !s.Go(func() {
+		<-release
+		close(finished)
+	}) {
+		t.Fatal("Go refused a task before Close")
+	}
+	if s.Idle() {
+		t.Error("scheduler idle with a running task")
+	}
+
+	_, idle := s.Close()
+	select {
+	case <-idle:
+		t.Fatal("scheduler idle before the task finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if s.Go(func() { t.Error("task started after Close") }) {
+		t.Error("Go accepted a task after Close")
+	}
+
+	close(release)
+	select {
+	case <-idle:
+	case <-time.After(5 * time.Second):
+		t.Fatal("scheduler not idle after the task finished")
+	}
+	<-finished
+}
@@ -0,0 +1,122 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+	"telecode/internal/session"
+)
+
+// callbackSwitchPrefix prefixes callback data of the /sessions buttons
+const callbackSwitchPrefix = "switch:"
+
+// handleSessions handles the /sessions command
+func (m *Manager) handleSessions(ctx context.Context, ws *WorkspaceBot, key session.Key) error {
+	sessions, active := ws.Bot.ListSessions(key)
+	if len(sessions) == 0 {
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			"📭 No sessions yet. Send a message to start one.",
+		))
+		return err
+	}
+
+	var sb strings.Builder
+	sb.WriteString("💬 Sessions\n")
+	var buttons []telego.InlineKeyboardButton
+	for _, s := range sessions {
+		marker := "  "
+		if s.Name == active {
+			marker = "▶️"
+		}
+		id := s.ID
+		if id == "" {
+			id = "not started"
+		}
+		fmt.Fprintf(&sb, "\n%s %s · %s · %s\n   %s", marker, s.Name, s.CLI, id, formatAge(s.UsedAt))
+		if s.LastPrompt != "" {
+			fmt.Fprintf(&sb, " · \"%s\"", s.LastPrompt)
+		}
+		sb.WriteString("\n")
+
+		buttons = append(buttons, tu.InlineKeyboardButton(s.Name).WithCallbackData(callbackSwitchPrefix+s.Name))
+	}
+
+	_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+		key,
+		sb.String(),
+	).WithReplyMarkup(tu.InlineKeyboardGrid(tu.InlineKeyboardCols(3, buttons...))))
+	return err
+}
+
+// handleSwitch handles the /switch command
+func (m *Manager) handleSwitch(ctx context.Context, ws *WorkspaceBot, key session.Key, text string) error {
+	args := strings.Fields(text)
+	if len(args) != 2 {
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			"❌ Usage: /switch <name> (see /sessions)",
+		))
+		return err
+	}
+	return m.switchSession(ctx, ws, key, args[1])
+}
+
+// switchSession activates a session and reports the result
+func (m *Manager) switchSession(ctx context.Context, ws *WorkspaceBot, key session.Key, name string) error {
+	s, err := ws.Bot.SwitchSession(key, name)
+	if err != nil {
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			fmt.Sprintf("❌ %v", err),
+		))
+		return err
+	}
+
+	_, err = ws.TgBot.SendMessage(ctx, chatMessage(
+		key,
+		fmt.Sprintf("✅ Switched to session <code>%s</code> (CLI: <code>%s</code>)",
+			html.EscapeString(s.Name), html.EscapeString(s.CLI)),
+	).WithParseMode(telego.ModeHTML))
+	return err
+}
+
+// handleResume handles the /resume command
+func (m *Manager) handleResume(ctx context.Context, ws *WorkspaceBot, key session.Key, text string) error {
+	args := strings.Fields(text)
+	if len(args) != 2 {
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			"❌ Usage: /resume <session-id>",
+		))
+		return err
+	}
+
+	s := ws.Bot.ResumeSession(key, args[1])
+	_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+		key,
+		fmt.Sprintf("✅ Resumed <code>%s</code> as session <code>%s</code> (CLI: <code>%s</code>)",
+			html.EscapeString(s.ID), html.EscapeString(s.Name), html.EscapeString(s.CLI)),
+	).WithParseMode(telego.ModeHTML))
+	return err
+}
+
+// formatAge formats how long ago t was
+func formatAge(t time.Time) string {
+	d := time.Since(t)
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd ago", int(d.Hours()/24))
+	}
+}
@@ -0,0 +1,100 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"time"
+
+	"github.com/mymmrac/telego"
+	"telecode/internal/session"
+)
+
+// shutdownNotifyTimeout bounds sending the shutdown notices
+const shutdownNotifyTimeout = 10 * time.Second
+
+// Shutdown stops all bots: no more updates are accepted, queued prompts are
+// dropped and the affected chats are notified. Running prompts get up to the
+// drain timeout to finish (cut short when ctx is done), then they are cancelled
+// like with /cancel. Shutdown returns once every run has exited and saved its session.
+func (m *Manager) Shutdown(ctx context.Context) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	bots := append(m.allWorkspaces(), m.retired...)
+
+	var idle []<-chan struct{}
+	busy := 0
+	for _, ws := range bots {
+		m.stopWorkspace(ws)
+
+		chats, done := ws.scheduler.Close()
+		idle = append(idle, done)
+		busy += len(chats)
+		for _, key := range chats {
+			m.notifyShutdown(ws, key)
+		}
+	}
+
+	// A prompt taken from the queue may still be preparing its run (worktree,
+	// checkpoint) without being registered yet, so the schedulers are waited
+	// for even when no run is; their channels are already closed when idle
+	if busy > 0 {
+		fmt.Printf("⏳ Waiting up to %s for %d running prompt(s)\n", m.drainTimeout, busy)
+	}
+	if waitIdle(ctx, idle, m.drainTimeout) {
+		return
+	}
+
+	// Cancelled runs save their session and report to their chat
+	fmt.Println("🛑 Cancelling remaining prompts")
+	for _, ws := range bots {
+		ws.cancelAllRuns()
+	}
+	if !waitIdle(context.Background(), idle, cancelGracePeriod+5*time.Second) {
+		fmt.Println("❌ Some prompts did not exit in time")
+	}
+}
+
+// allWorkspaces returns the active workspace bots. Caller must hold m.mu.
+func (m *Manager) allWorkspaces() []*WorkspaceBot {
+	bots := make([]*WorkspaceBot, 0, len(m.workspaces))
+	for _, ws := range m.workspaces {
+		bots = append(bots, ws)
+	}
+	return bots
+}
+
+// notifyShutdown tells a chat that its queued prompts were dropped and how long
+// a running prompt may still take
+func (m *Manager) notifyShutdown(ws *WorkspaceBot, key session.Key) {
+	text := "🛑 <b>Telecode is shutting down.</b> Queued prompts were dropped."
+	if ws.isRunning(key) {
+		text += fmt.Sprintf("\n\nThe running prompt has up to <code>%s</code> to finish before it is cancelled.",
+			html.EscapeString(m.drainTimeout.String()))
+	}
+
+	ctx, cancel := context.WithTimeout(m.ctx, shutdownNotifyTimeout)
+	defer cancel()
+	if _, err := ws.TgBot.SendMessage(ctx, chatMessage(key, text).WithParseMode(telego.ModeHTML)); err != nil {
+		fmt.Printf("❌ Failed to notify chat %s of %s: %v\n", key, ws.Config().Name, err)
+	}
+}
+
+// waitIdle waits until all channels are closed, returning false if timeout
+// expires or ctx is done first
+func waitIdle(ctx context.Context, idle []<-chan struct{}, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for _, done := range idle {
+		select {
+		case <-done:
+		case <-timer.C:
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,178 @@
+package bot
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"html"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mymmrac/telego"
+	"telecode/internal/executor"
+	"telecode/internal/session"
+	"telecode/internal/usage"
+)
+
+// recordUsage adds the usage of a finished run to the ledger and returns
+// warnings for the budget thresholds it crossed
+func (m *Manager) recordUsage(ws *WorkspaceBot, j *job, cli string, e executor.Executor, output string, duration time.Duration) []string {
+	totals := usage.Totals{DurationMS: duration.Milliseconds()}
+	if reporter, ok := e.(executor.UsageReporter); ok {
+		u := reporter.ParseUsage(output)
+		totals.InputTokens = u.InputTokens
+		totals.OutputTokens = u.OutputTokens
+		totals.CacheReadTokens = u.CacheReadTokens
+		totals.CacheWriteTokens = u.CacheWriteTokens
+		totals.CostUSD = u.CostUSD
+	}
+
+	now := time.Now()
+	before := m.budgetLimits(ws, j.userID, now)
+	m.ledger.Add(usage.Run{
+		Time:      now,
+		Workspace: ws.Config().Name,
+		ChatID:    j.key.ChatID,
+		UserID:    j.userID,
+		UserName:  j.userName,
+		CLI:       cli,
+		Usage:     totals,
+	})
+	return budgetWarnings(before, m.budgetLimits(ws, j.userID, now), ws.Config().Budget.WarnAt)
+}
+
+// handleStats handles the /stats command: usage of the workspace, or the
+// CLI's own statistics with /stats cli
+func (m *Manager) handleStats(ctx context.Context, ws *WorkspaceBot, key session.Key, r role, text string) error {
+	if args := strings.Fields(text); len(args) > 1 && args[1] == "cli" {
+		return m.handleCLIStats(ctx, ws, key)
+	}
+
+	name := ws.Config().Name
+	inWorkspace := func(e usage.Entry) bool { return e.Workspace == name }
+	inChat := func(e usage.Entry) bool { return inWorkspace(e) && e.ChatID == key.ChatID }
+
+	now := time.Now()
+	month := usage.StartOfMonth(now)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "📊 <b>Usage of %s</b>\n<pre>", html.EscapeString(name))
+	fmt.Fprintf(&sb, "%-11s %5s %8s %9s\n", "", "Runs", "Tokens", "Cost")
+	for _, period := range []struct {
+		label string
+		since time.Time
+	}{
+		{"Today", usage.StartOfDay(now)},
+		{"This week", usage.StartOfWeek(now)},
+		{"This month", month},
+	} {
+		t := m.ledger.Sum(period.since, inWorkspace)
+		fmt.Fprintf(&sb, "%-11s %5d %8s %9s\n", period.label, t.Runs, formatTokens(t.Tokens()), formatCost(t.CostUSD))
+	}
+	sb.WriteString("</pre>\n")
+
+	fmt.Fprintf(&sb, "<b>This chat</b> (month): %s\n", formatTotals(m.ledger.Sum(month, inChat)))
+
+	entries := m.ledger.Entries(month, inWorkspace)
+	writeBreakdown(&sb, "By CLI", entries, func(e usage.Entry) string { return e.CLI })
+
+	// Other users' spending is only shown to admins
+	if r >= roleAdmin {
+		writeBreakdown(&sb, "By user", entries, func(e usage.Entry) string {
+			if e.UserID == 0 {
+				return "anonymous"
+			}
+			if userName := m.ledger.UserName(e.UserID); userName != "" {
+				return userName
+			}
+			return fmt.Sprint(e.UserID)
+		})
+	}
+
+	sb.WriteString("\nUse /stats cli for the CLI's own statistics.")
+
+	_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+		key,
+		sb.String(),
+	).WithParseMode(telego.ModeHTML))
+	return err
+}
+
+// handleCLIStats shows the statistics reported by the chat's CLI
+func (m *Manager) handleCLIStats(ctx context.Context, ws *WorkspaceBot, key session.Key) error {
+	stats, err := ws.Bot.GetStats(key)
+	if err != nil {
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			fmt.Sprintf("❌ %v", err),
+		))
+		return err
+	}
+
+	_, err = ws.TgBot.SendMessage(ctx, chatMessage(
+		key,
+		fmt.Sprintf("📊 <b>Statistics</b>\n<pre>%s</pre>", html.EscapeString(stats)),
+	).WithParseMode(telego.ModeHTML))
+	return err
+}
+
+// writeBreakdown writes the month's totals grouped by label, most expensive first
+func writeBreakdown(sb *strings.Builder, title string, entries []usage.Entry, label func(usage.Entry) string) {
+	if len(entries) == 0 {
+		return
+	}
+
+	groups := make(map[string]*usage.Totals)
+	for _, e := range entries {
+		name := label(e)
+		if groups[name] == nil {
+			groups[name] = &usage.Totals{}
+		}
+		groups[name].Add(e.Totals)
+	}
+
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	slices.SortFunc(names, func(a, b string) int {
+		ga, gb := groups[a], groups[b]
+		return cmp.Or(cmp.Compare(gb.CostUSD, ga.CostUSD), cmp.Compare(gb.Tokens(), ga.Tokens()), cmp.Compare(a, b))
+	})
+
+	fmt.Fprintf(sb, "\n<b>%s</b> (month):\n", title)
+	for _, name := range names {
+		fmt.Fprintf(sb, "- %s: %s\n", html.EscapeString(name), formatTotals(*groups[name]))
+	}
+}
+
+// formatTotals formats usage totals on one line
+func formatTotals(t usage.Totals) string {
+	return fmt.Sprintf("%d runs · %s tokens · %s · %s",
+		t.Runs, formatTokens(t.Tokens()), formatCost(t.CostUSD), (time.Duration(t.DurationMS) * time.Millisecond).Round(time.Second))
+}
+
+// formatTokens formats a token count as 950, 12.3k or 1.2M
+func formatTokens(n int64) string {
+	switch {
+	case n >= 1_000_000:
+		return fmt.Sprintf("%.1fM", float64(n)/1e6)
+	case n >= 1_000:
+		return fmt.Sprintf("%.1fk", float64(n)/1e3)
+	}
+	return fmt.Sprint(n)
+}
+
+// formatCost formats a cost in US dollars
+func formatCost(usd float64) string {
+	return fmt.Sprintf("$%.2f", usd)
+}
+
+// displayName returns the name shown for a Telegram user
+func displayName(user *telego.User) string {
+	if user.Username != "" {
+		return "@" + user.Username
+	}
+	return strings.TrimSpace(user.FirstName + " " + user.LastName)
+}
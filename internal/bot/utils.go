@@ -5,55 +5,183 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os/exec"
 	"regexp"
 	"strings"
 	"time"
+
+	"telecode/internal/executor"
+)
+
+var (
+	// ansiRegex matches ANSI escape sequences (color codes, cursor movements, etc.)
+	ansiRegex = regexp.MustCompile(`\x1b\[[0-9;]*[a-zA-Z]`)
+	// oscRegex matches OSC sequences (terminal title changes, etc.)
+	oscRegex = regexp.MustCompile(`\x1b\][0-9;]*.*?\x07`)
+	// escapeRegex matches any remaining escape sequences
+	escapeRegex = regexp.MustCompile(`\x1b\[[\?0-9]*[hl]`)
 )
 
 // stripAnsiCodes removes ANSI escape sequences and OSC sequences from text
 func stripAnsiCodes(text string) string {
-	// Remove ANSI escape sequences (color codes, cursor movements, etc.)
-	ansiRegex := regexp.MustCompile(`\x1b\[[0-9;]*[a-zA-Z]`)
-	// Remove OSC sequences (terminal title changes, etc.)
-	oscRegex := regexp.MustCompile(`\x1b\][0-9;]*.*?\x07`)
-	// Remove any remaining escape sequences
-	escapeRegex := regexp.MustCompile(`\x1b\[[\?0-9]*[hl]`)
-
 	text = ansiRegex.ReplaceAllString(text, "")
 	text = oscRegex.ReplaceAllString(text, "")
 	text = escapeRegex.ReplaceAllString(text, "")
 	return text
 }
 
-// runCommandWithDir executes a CLI command in a specific working directory
-func runCommandWithDir(cmd []string, workingDir string, timeout time.Duration) string {
+// truncateRunes shortens s to n runes on a single line, adding an ellipsis if truncated
+func truncateRunes(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n]) + "…"
+}
+
+// cancelGracePeriod is how long a cancelled command may take to exit after
+// SIGINT before its whole process group is killed
+const cancelGracePeriod = 10 * time.Second
+
+// runCommandWithDir executes a CLI command in a specific working directory.
+// Output is read incrementally and every line is passed to onLine (if set)
+// while the process is still running. Cancelling ctx interrupts the command.
+func runCommandWithDir(ctx context.Context, cmd []string, workingDir string, timeout time.Duration, onLine func(string)) string {
 	if len(cmd) == 0 {
 		return "Error: Command is empty"
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	command := exec.CommandContext(ctx, cmd[0], cmd[1:]...)
 	command.Dir = workingDir // Set working directory
-	output, err := command.CombinedOutput()
+
+	// Run in its own process group: interrupt first, kill everything after the grace period
+	setProcessGroup(command)
+	command.Cancel = func() error { return interruptProcessGroup(command) }
+	command.WaitDelay = cancelGracePeriod
+
+	// Merge stdout and stderr into a single stream, like CombinedOutput
+	pr, pw := io.Pipe()
+	command.Stdout = pw
+	command.Stderr = pw
+
+	if err := command.Start(); err != nil {
+		return stripAnsiCodes(fmt.Sprintf("Error: %v", err))
+	}
+
+	waitErr := make(chan error, 1)
+	exited := make(chan struct{})
+	go func() {
+		err := command.Wait()
+		close(exited)
+		pw.Close()
+		waitErr <- err
+	}()
+	go func() {
+		select {
+		case <-exited:
+		case <-ctx.Done():
+			timer := time.NewTimer(cancelGracePeriod)
+			defer timer.Stop()
+			select {
+			case <-exited:
+			case <-timer.C:
+				_ = killProcessGroup(command)
+			}
+		}
+	}()
+
+	var output strings.Builder
+	reader := bufio.NewReader(pr)
+	for {
+		line, readErr := reader.ReadString('\n')
+		if line != "" {
+			output.WriteString(line)
+			if onLine != nil {
+				onLine(stripAnsiCodes(line))
+			}
+		}
+		if readErr != nil {
+			break
+		}
+	}
+	err := <-waitErr
 
 	if ctx.Err() == context.DeadlineExceeded {
 		return fmt.Sprintf("Error: Command execution timeout (%v)", timeout)
 	}
 
 	if err != nil {
-		return stripAnsiCodes(fmt.Sprintf("Error: %v\n%s", err, string(output)))
+		return stripAnsiCodes(fmt.Sprintf("Error: %v\n%s", err, output.String()))
+	}
+
+	return stripAnsiCodes(output.String())
+}
+
+// extractProgressText converts partial CLI output into the text shown while the command runs
+func extractProgressText(e executor.Executor, output string) string {
+	// Raw JSON is noise until the first text event arrives
+	switch e := e.(type) {
+	case *executor.ClaudeExecutor:
+		return strings.Join(executor.ParseClaudeStream(output).Steps, "\n\n")
+	case *executor.OpenCodeExecutor:
+		return strings.Join(openCodeTexts(output), "\n\n")
+	case *executor.CodexExecutor:
+		return strings.Join(executor.ParseCodexStream(output).Steps, "\n\n")
+	case *executor.GeminiExecutor:
+		return strings.Join(executor.ParseGeminiStream(output).Steps, "\n\n")
+	case executor.OutputExtractor:
+		return e.ExtractOutput(output)
 	}
+	return output
+}
 
-	return stripAnsiCodes(string(output))
+// extractOutputText converts raw CLI output into the text shown to the user
+func extractOutputText(e executor.Executor, output string) string {
+	switch e := e.(type) {
+	case *executor.ClaudeExecutor:
+		// Return original if no events were found (e.g. an error message)
+		if text := executor.ParseClaudeStream(output).Text(); text != "" {
+			return text
+		}
+	case *executor.OpenCodeExecutor:
+		// For OpenCode, extract text from JSON output
+		return extractTextFromOpenCodeJSON(output)
+	case *executor.CodexExecutor:
+		if text := executor.ParseCodexStream(output).Text(); text != "" {
+			return text
+		}
+	case *executor.GeminiExecutor:
+		if text := executor.ParseGeminiStream(output).Text(); text != "" {
+			return text
+		}
+	case executor.OutputExtractor:
+		if text := e.ExtractOutput(output); text != "" {
+			return text
+		}
+	}
+	return output
 }
 
 // extractTextFromOpenCodeJSON parses OpenCode JSON output and extracts text responses
 func extractTextFromOpenCodeJSON(output string) string {
+	texts := openCodeTexts(output)
+	if len(texts) == 0 {
+		return output // Return original if no text found
+	}
+
+	return strings.Join(texts, "\n\n")
+}
+
+// openCodeTexts returns the text parts found in OpenCode JSON output
+func openCodeTexts(output string) []string {
 	var texts []string
 	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
 
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
@@ -77,9 +205,5 @@ func extractTextFromOpenCodeJSON(output string) string {
 		}
 	}
 
-	if len(texts) == 0 {
-		return output // Return original if no text found
-	}
-
-	return strings.Join(texts, "\n\n")
+	return texts
 }
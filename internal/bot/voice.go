@@ -0,0 +1,213 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+	"telecode/internal/session"
+)
+
+const (
+	// transcribeTimeout bounds a single run of the transcription command
+	transcribeTimeout = 2 * time.Minute
+
+	// transcriptTTL is how long an unconfirmed transcript can still be run
+	transcriptTTL = time.Hour
+
+	// callbackVoicePrefix prefixes callback data of the transcript buttons
+	callbackVoicePrefix = "voice:"
+)
+
+// transcriptKey identifies the message showing a transcript
+type transcriptKey struct {
+	key       session.Key
+	messageID int
+}
+
+// pendingTranscript is a transcript waiting for confirmation
+type pendingTranscript struct {
+	text    string
+	created time.Time
+}
+
+// transcripts holds transcripts waiting for confirmation
+type transcripts struct {
+	pending map[transcriptKey]pendingTranscript
+	mu      sync.Mutex
+}
+
+// add stores a transcript and forgets expired ones
+func (t *transcripts) add(key transcriptKey, text string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for k, p := range t.pending {
+		if time.Since(p.created) > transcriptTTL {
+			delete(t.pending, k)
+		}
+	}
+	t.pending[key] = pendingTranscript{text: text, created: time.Now()}
+}
+
+// take removes and returns a transcript
+func (t *transcripts) take(key transcriptKey) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.pending[key]
+	delete(t.pending, key)
+	return p.text, ok
+}
+
+// handleVoiceMessage transcribes a voice note or audio file and asks the user to confirm it
+func (m *Manager) handleVoiceMessage(ctx context.Context, ws *WorkspaceBot, message *telego.Message) error {
+	key := keyFor(message)
+
+	if len(ws.Config().TranscribeCommand) == 0 {
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			"❌ Voice messages are not enabled (set transcribe_command in the config)",
+		))
+		return err
+	}
+
+	fileID, size, name := "", int64(0), "voice.ogg"
+	if message.Voice != nil {
+		fileID, size = message.Voice.FileID, message.Voice.FileSize
+	} else {
+		fileID, size = message.Audio.FileID, message.Audio.FileSize
+		if message.Audio.FileName != "" {
+			name = unsafeFileChars.ReplaceAllString(message.Audio.FileName, "_")
+		}
+	}
+
+	maxSize := int64(ws.Config().MaxFileSizeMB) << 20
+	if size > maxSize {
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			fmt.Sprintf("❌ Audio is too large (%.1f MB, limit %d MB)", float64(size)/(1<<20), ws.Config().MaxFileSizeMB),
+		))
+		return err
+	}
+
+	_ = ws.TgBot.SendChatAction(ctx, tu.ChatAction(tu.ID(key.ChatID), telego.ChatActionTyping).WithMessageThreadID(key.ThreadID))
+
+	file, err := ws.TgBot.GetFile(ctx, &telego.GetFileParams{FileID: fileID})
+	if err != nil {
+		_, _ = ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			"❌ Failed to get audio info",
+		))
+		return err
+	}
+
+	// Voice notes are always temporary, even if an inbox is configured
+	tempDir, err := os.MkdirTemp("", fmt.Sprintf("telecode_voice_%d_", key.ChatID))
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	localPath := filepath.Join(tempDir, name)
+	if err := downloadFile(ctx, ws.Config().BotToken, file.FilePath, localPath, maxSize); err != nil {
+		_, _ = ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			"❌ Failed to download audio",
+		))
+		return err
+	}
+
+	transcript, err := transcribe(ctx, ws.Config().TranscribeCommand, localPath, ws.Config().WorkingDir)
+	if err != nil || transcript == "" {
+		if err == nil {
+			err = fmt.Errorf("empty transcript")
+		}
+		_, _ = ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			fmt.Sprintf("❌ Transcription failed: %v", err),
+		))
+		return err
+	}
+
+	// Echo the transcript so a misheard prompt is not run by accident
+	sent, err := ws.TgBot.SendMessage(ctx, chatMessage(
+		key,
+		"🎙 "+transcript,
+	).WithReplyMarkup(tu.InlineKeyboard(tu.InlineKeyboardRow(
+		tu.InlineKeyboardButton("▶️ Run").WithCallbackData(callbackVoicePrefix+"run"),
+		tu.InlineKeyboardButton("🗑 Discard").WithCallbackData(callbackVoicePrefix+"drop"),
+	))))
+	if err != nil {
+		return err
+	}
+	ws.voice.add(transcriptKey{key: key, messageID: sent.MessageID}, transcript)
+	return nil
+}
+
+// handleVoiceCallback runs or discards a transcript after the user pressed a button
+func (m *Manager) handleVoiceCallback(ctx context.Context, ws *WorkspaceBot, query *telego.CallbackQuery, r role) error {
+	key := callbackKey(query)
+	messageID := query.Message.GetMessageID()
+
+	transcript, ok := ws.voice.take(transcriptKey{key: key, messageID: messageID})
+	if !ok {
+		_, err := ws.TgBot.EditMessageReplyMarkup(ctx, tu.EditMessageReplyMarkup(tu.ID(key.ChatID), messageID, nil))
+		return err
+	}
+
+	action := strings.TrimPrefix(query.Data, callbackVoicePrefix)
+	status := "🗑 Discarded"
+	if action == "run" {
+		status = "▶️ Sent"
+	}
+	_, _ = ws.TgBot.EditMessageText(ctx, tu.EditMessageText(tu.ID(key.ChatID), messageID, "🎙 "+transcript+"\n\n"+status))
+
+	if action != "run" {
+		return nil
+	}
+	// The prompt runs with the role of the user who pressed Run
+	return m.handleMessage(ctx, ws, key, &query.From, r, transcript, nil, nil)
+}
+
+// transcribe runs the configured speech-to-text command on an audio file and
+// returns its standard output. The {file} placeholder is replaced by the audio
+// path; without a placeholder the path is appended as last argument.
+func transcribe(ctx context.Context, command []string, audioPath, workingDir string) (string, error) {
+	args := make([]string, 0, len(command)+1)
+	replaced := false
+	for _, arg := range command {
+		if strings.Contains(arg, "{file}") {
+			arg = strings.ReplaceAll(arg, "{file}", audioPath)
+			replaced = true
+		}
+		args = append(args, arg)
+	}
+	if !replaced {
+		args = append(args, audioPath)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, transcribeTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = workingDir
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%w: %s", err, truncateRunes(msg, 300))
+		}
+		return "", err
+	}
+
+	// Join lines: transcribers often wrap output or print one segment per line
+	return strings.Join(strings.Fields(stdout.String()), " "), nil
+}
@@ -0,0 +1,171 @@
+package bot
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mymmrac/telego"
+	"telecode/internal/config"
+)
+
+// maxWebhookBody limits the size of a webhook request
+const maxWebhookBody = 1 << 20
+
+// webhookRoute delivers the webhook requests of one workspace
+type webhookRoute struct {
+	ws      *WorkspaceBot
+	secret  string
+	handler telego.WebhookHandler
+}
+
+// webhookServer receives the webhook updates of all workspaces on one HTTP server,
+// routing /webhook/<workspace> to the matching bot. Routes can be added and
+// removed while the server is running.
+type webhookServer struct {
+	cfg    config.WebhookConfig
+	routes map[string]webhookRoute
+	mu     sync.RWMutex
+}
+
+// newWebhookServer creates a webhook server
+func newWebhookServer(cfg config.WebhookConfig) *webhookServer {
+	return &webhookServer{
+		cfg:    cfg,
+		routes: make(map[string]webhookRoute),
+	}
+}
+
+// register sets the Telegram webhook of a workspace and returns its updates
+func (s *webhookServer) register(ctx context.Context, ws *WorkspaceBot) (<-chan telego.Update, error) {
+	cfg := ws.Config()
+
+	secret := cfg.WebhookSecret
+	if secret == "" {
+		var err error
+		if secret, err = randomSecret(); err != nil {
+			return nil, err
+		}
+	}
+
+	updates, err := ws.TgBot.UpdatesViaWebhook(ctx,
+		func(handler telego.WebhookHandler) error {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.routes[cfg.Name] = webhookRoute{ws: ws, secret: secret, handler: handler}
+			return nil
+		},
+		telego.WithWebhookSet(ctx, &telego.SetWebhookParams{
+			URL:         strings.TrimRight(s.cfg.URL, "/") + "/webhook/" + url.PathEscape(cfg.Name),
+			SecretToken: secret,
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start webhook: %w", err)
+	}
+	return updates, nil
+}
+
+// unregister stops routing requests to a workspace and deletes its Telegram webhook
+func (s *webhookServer) unregister(ctx context.Context, ws *WorkspaceBot) {
+	name := ws.Config().Name
+
+	s.mu.Lock()
+	route, ok := s.routes[name]
+	if ok && route.ws == ws {
+		delete(s.routes, name)
+	}
+	s.mu.Unlock()
+
+	if ok && route.ws == ws {
+		if err := ws.TgBot.DeleteWebhook(ctx, nil); err != nil {
+			fmt.Printf("❌ Failed to delete webhook for workspace %s: %v\n", name, err)
+		}
+	}
+}
+
+// serveUpdate passes a webhook request to the workspace it is addressed to
+func (s *webhookServer) serveUpdate(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	s.mu.RLock()
+	route, ok := s.routes[r.PathValue("name")]
+	s.mu.RUnlock()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Requests without the matching secret token header are rejected
+	token := r.Header.Get(telego.WebhookSecretTokenHeader)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(route.secret)) != 1 {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err := route.handler(r.Context(), data); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// run serves webhook requests until ctx is done, then deregisters the webhooks
+func (s *webhookServer) run(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /webhook/{name}", s.serveUpdate)
+
+	server := &http.Server{
+		Addr:              s.cfg.Listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	s.mu.RLock()
+	routes := make([]webhookRoute, 0, len(s.routes))
+	for _, route := range s.routes {
+		routes = append(routes, route)
+	}
+	s.mu.RUnlock()
+
+	for _, route := range routes {
+		s.unregister(shutdownCtx, route.ws)
+	}
+	return server.Shutdown(shutdownCtx)
+}
+
+// randomSecret generates a webhook secret token
+func randomSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
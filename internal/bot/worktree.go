@@ -0,0 +1,390 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+	"telecode/internal/config"
+	"telecode/internal/git"
+	"telecode/internal/session"
+)
+
+const (
+	// isolationWorktree runs every session in its own git worktree
+	isolationWorktree = "worktree"
+
+	// worktreeBranchPrefix prefixes the branches of session worktrees
+	worktreeBranchPrefix = "telecode/"
+
+	// gitTimeout bounds the git commands run for worktree management
+	gitTimeout = time.Minute
+
+	// callbackDiscardPrefix prefixes callback data of the /discard confirmation button
+	callbackDiscardPrefix = "discard:"
+)
+
+// conversationSlug names a conversation in branch and directory names, e.g. "chat100123-t5"
+func conversationSlug(key session.Key) string {
+	slug := fmt.Sprintf("chat%d", key.ChatID)
+	if key.ThreadID != 0 {
+		slug += fmt.Sprintf("-t%d", key.ThreadID)
+	}
+	return slug
+}
+
+// sessionBranch returns the branch of a session's worktree, e.g. "telecode/chat100123/s1"
+func sessionBranch(key session.Key, name string) string {
+	return worktreeBranchPrefix + conversationSlug(key) + "/" + name
+}
+
+// sessionWorktreePath returns the directory of a session's worktree, which
+// is always directly inside the worktree directory
+func sessionWorktreePath(cfg config.WorkspaceConfig, key session.Key, name string) (string, error) {
+	dir := cfg.WorktreeDir
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(cfg.WorkingDir, dir)
+	}
+	path := filepath.Join(dir, conversationSlug(key)+"-"+name)
+	if !session.ValidName(name) || filepath.Dir(path) != filepath.Clean(dir) {
+		return "", fmt.Errorf("invalid session name %s", name)
+	}
+	return path, nil
+}
+
+// runDir returns the directory the agent of a chat runs in: the working
+// directory, or with worktree isolation the worktree of the active session,
+// which is created (with a session, if the chat has none yet) on first use
+func (m *Manager) runDir(ctx context.Context, ws *WorkspaceBot, key session.Key) (string, error) {
+	cfg := ws.Config()
+	if cfg.Isolation != isolationWorktree {
+		return cfg.WorkingDir, nil
+	}
+
+	// The worktree belongs to a session, so it needs a name before the first run
+	active, ok := ws.Bot.ActiveSession(key)
+	if !ok {
+		var err error
+		if active, err = ws.Bot.NewSession(key, ""); err != nil {
+			return "", err
+		}
+	}
+
+	path, err := sessionWorktreePath(cfg, key, active.Name)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(filepath.Join(path, ".git")); err == nil {
+		return path, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, gitTimeout)
+	defer cancel()
+	if !git.IsRepo(ctx, cfg.WorkingDir) {
+		return "", errors.New("worktree isolation needs working_dir to be a git repository")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	branch := sessionBranch(key, active.Name)
+	if err := git.AddWorktree(ctx, cfg.WorkingDir, path, branch); err != nil {
+		return "", err
+	}
+	fmt.Printf("🌿 Created worktree %s on branch %s for %s\n", path, branch, cfg.Name)
+	return path, nil
+}
+
+// requireWorktrees tells the chat when worktree isolation is off and reports whether it is on
+func (m *Manager) requireWorktrees(ctx context.Context, ws *WorkspaceBot, key session.Key) (bool, error) {
+	if ws.Config().Isolation == isolationWorktree {
+		return true, nil
+	}
+	_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+		key,
+		"ℹ️ Sessions of this workspace share the working directory. Set isolation: worktree to give each session its own branch.",
+	))
+	return false, err
+}
+
+// worktreeSession returns the session named in a /merge or /discard command,
+// or the active one ("" if there is none)
+func (ws *WorkspaceBot) worktreeSession(key session.Key, text string) (string, error) {
+	if args := strings.Fields(text); len(args) > 1 {
+		return ws.knownSession(key, args[1])
+	}
+	active, _ := ws.Bot.ActiveSession(key)
+	return active.Name, nil
+}
+
+// knownSession returns name if it is one of the chat's sessions. Names from
+// commands and callback data are checked before they end up in paths and branches.
+func (ws *WorkspaceBot) knownSession(key session.Key, name string) (string, error) {
+	sessions, _ := ws.Bot.ListSessions(key)
+	for _, s := range sessions {
+		if s.Name == name {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("unknown session %s, see /sessions", name)
+}
+
+// handleWorktrees handles the /worktrees command: the session worktrees of the chat
+func (m *Manager) handleWorktrees(ctx context.Context, ws *WorkspaceBot, key session.Key) error {
+	if ok, err := m.requireWorktrees(ctx, ws, key); !ok {
+		return err
+	}
+
+	cfg := ws.Config()
+	gitCtx, cancel := context.WithTimeout(ctx, gitTimeout)
+	defer cancel()
+
+	worktrees, err := git.ListWorktrees(gitCtx, cfg.WorkingDir)
+	if err != nil {
+		return m.sendGitError(ctx, ws, key, err)
+	}
+	base, err := git.CurrentBranch(gitCtx, cfg.WorkingDir)
+	if err != nil {
+		return m.sendGitError(ctx, ws, key, err)
+	}
+
+	active, _ := ws.Bot.ActiveSession(key)
+	current := sessionBranch(key, active.Name)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "🌿 <b>Session worktrees</b> (base <code>%s</code>)\n", html.EscapeString(base))
+	count := 0
+	// Only the sessions of this conversation, like /merge and /discard
+	prefix := worktreeBranchPrefix + conversationSlug(key) + "/"
+	for _, w := range worktrees {
+		if !strings.HasPrefix(w.Branch, prefix) {
+			continue
+		}
+		count++
+
+		fmt.Fprintf(&sb, "\n- <code>%s</code>", html.EscapeString(w.Branch))
+		if w.Branch == current {
+			sb.WriteString(" (this session)")
+		}
+		var details []string
+		if ahead, err := git.CountCommits(gitCtx, cfg.WorkingDir, base, w.Branch); err == nil {
+			details = append(details, fmt.Sprintf("%d commit(s) ahead", ahead))
+		}
+		if changes, err := git.Changes(gitCtx, w.Path); err == nil && len(changes) > 0 {
+			details = append(details, fmt.Sprintf("%d uncommitted file(s)", len(changes)))
+		}
+		if len(details) > 0 {
+			sb.WriteString("\n  " + strings.Join(details, ", "))
+		}
+	}
+	if count == 0 {
+		sb.WriteString("\nNo worktrees yet, one is created for each session on its first prompt.")
+	} else {
+		sb.WriteString("\n\nUse /merge to merge this session's branch, /discard to delete it.")
+	}
+
+	_, err = ws.TgBot.SendMessage(ctx, chatMessage(
+		key,
+		sb.String(),
+	).WithParseMode(telego.ModeHTML))
+	return err
+}
+
+// handleMerge handles the /merge command: commits the session's pending
+// changes and merges its branch into the branch checked out in the working
+// directory. The worktree is kept so the session can go on.
+func (m *Manager) handleMerge(ctx context.Context, ws *WorkspaceBot, key session.Key, text string) error {
+	if ok, err := m.requireWorktrees(ctx, ws, key); !ok {
+		return err
+	}
+
+	name, err := ws.worktreeSession(key, text)
+	if err != nil {
+		return m.sendGitError(ctx, ws, key, err)
+	}
+	if name == "" {
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			"❌ No active session. Usage: /merge [session]",
+		))
+		return err
+	}
+	if active, _ := ws.Bot.ActiveSession(key); active.Name == name && ws.isRunning(key) {
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			"⏳ The agent is still working in this session, wait for it or /cancel it first.",
+		))
+		return err
+	}
+
+	cfg := ws.Config()
+	path, err := sessionWorktreePath(cfg, key, name)
+	if err != nil {
+		return m.sendGitError(ctx, ws, key, err)
+	}
+	branch := sessionBranch(key, name)
+	gitCtx, cancel := context.WithTimeout(ctx, gitTimeout)
+	defer cancel()
+
+	if _, err := os.Stat(path); err != nil {
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			fmt.Sprintf("❌ Session %s has no worktree.", name),
+		))
+		return err
+	}
+	if _, err := git.CommitAll(gitCtx, path, fmt.Sprintf("Changes from telecode session %s", name), gitAuthor(cfg)); err != nil {
+		return m.sendGitError(ctx, ws, key, err)
+	}
+
+	base, err := git.CurrentBranch(gitCtx, cfg.WorkingDir)
+	if err != nil {
+		return m.sendGitError(ctx, ws, key, err)
+	}
+	ahead, err := git.CountCommits(gitCtx, cfg.WorkingDir, base, branch)
+	if err != nil {
+		return m.sendGitError(ctx, ws, key, err)
+	}
+	if ahead == 0 {
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			fmt.Sprintf("ℹ️ %s has nothing to merge into %s.", branch, base),
+		))
+		return err
+	}
+
+	summary, err := git.Merge(gitCtx, cfg.WorkingDir, branch, fmt.Sprintf("Merge telecode session %s", name), gitAuthor(cfg))
+	var conflict *git.ConflictError
+	if errors.As(err, &conflict) {
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			fmt.Sprintf("⚠️ %s conflicts with %s in:\n- %s\n\nThe merge was aborted. Ask the agent to merge %s into its branch and resolve the conflicts, then /merge again.",
+				branch, base, strings.Join(conflict.Files, "\n- "), base),
+		))
+		return err
+	}
+	if err != nil {
+		return m.sendGitError(ctx, ws, key, err)
+	}
+
+	fmt.Printf("🔀 Merged %s into %s for %s\n", branch, base, cfg.Name)
+	_, err = ws.TgBot.SendMessage(ctx, chatMessage(
+		key,
+		fmt.Sprintf("✅ Merged %s into %s (%d commit(s)): %s", branch, base, ahead, summary),
+	))
+	return err
+}
+
+// handleDiscard handles the /discard command by asking to confirm deleting
+// the worktree and branch of a session
+func (m *Manager) handleDiscard(ctx context.Context, ws *WorkspaceBot, key session.Key, text string) error {
+	if ok, err := m.requireWorktrees(ctx, ws, key); !ok {
+		return err
+	}
+
+	name, err := ws.worktreeSession(key, text)
+	if err != nil {
+		return m.sendGitError(ctx, ws, key, err)
+	}
+	if name == "" {
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			"❌ No active session. Usage: /discard [session]",
+		))
+		return err
+	}
+
+	cfg := ws.Config()
+	branch := sessionBranch(key, name)
+	gitCtx, cancel := context.WithTimeout(ctx, gitTimeout)
+	defer cancel()
+	if !git.BranchExists(gitCtx, cfg.WorkingDir, branch) {
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			fmt.Sprintf("❌ Session %s has no worktree.", name),
+		))
+		return err
+	}
+
+	warning := "Its changes are lost unless they were merged."
+	if base, err := git.CurrentBranch(gitCtx, cfg.WorkingDir); err == nil {
+		if ahead, err := git.CountCommits(gitCtx, cfg.WorkingDir, base, branch); err == nil && ahead > 0 {
+			warning = fmt.Sprintf("%d commit(s) not merged into %s will be lost.", ahead, base)
+		}
+	}
+
+	_, err = ws.TgBot.SendMessage(ctx, chatMessage(
+		key,
+		fmt.Sprintf("🗑 Delete the worktree and branch %s? %s", branch, warning),
+	).WithReplyMarkup(tu.InlineKeyboard(tu.InlineKeyboardRow(
+		tu.InlineKeyboardButton("🗑 Discard").WithCallbackData(callbackDiscardPrefix+name),
+	))))
+	return err
+}
+
+// discardWorktree deletes the worktree and branch of a session after the
+// user confirmed; the session is reset since its directory is gone
+func (m *Manager) discardWorktree(ctx context.Context, ws *WorkspaceBot, query *telego.CallbackQuery) error {
+	key := callbackKey(query)
+	_, _ = ws.TgBot.EditMessageReplyMarkup(ctx, tu.EditMessageReplyMarkup(tu.ID(key.ChatID), query.Message.GetMessageID(), nil))
+	name, err := ws.knownSession(key, strings.TrimPrefix(query.Data, callbackDiscardPrefix))
+	if err != nil {
+		return m.sendGitError(ctx, ws, key, err)
+	}
+
+	active, _ := ws.Bot.ActiveSession(key)
+	if active.Name == name && ws.isRunning(key) {
+		_, err := ws.TgBot.SendMessage(ctx, chatMessage(
+			key,
+			"⏳ The agent is still working in this session, wait for it or /cancel it first.",
+		))
+		return err
+	}
+
+	cfg := ws.Config()
+	path, err := sessionWorktreePath(cfg, key, name)
+	if err != nil {
+		return m.sendGitError(ctx, ws, key, err)
+	}
+	branch := sessionBranch(key, name)
+	gitCtx, cancel := context.WithTimeout(ctx, gitTimeout)
+	defer cancel()
+
+	if _, err := os.Stat(path); err == nil {
+		if err := git.RemoveWorktree(gitCtx, cfg.WorkingDir, path); err != nil {
+			return m.sendGitError(ctx, ws, key, err)
+		}
+	}
+	if git.BranchExists(gitCtx, cfg.WorkingDir, branch) {
+		if err := git.DeleteBranch(gitCtx, cfg.WorkingDir, branch); err != nil {
+			return m.sendGitError(ctx, ws, key, err)
+		}
+	}
+
+	reply := fmt.Sprintf("🗑 Discarded %s.", branch)
+	if active.Name == name {
+		ws.Bot.ResetSession(key)
+		reply += " The next prompt starts a new session."
+	}
+	fmt.Printf("🗑 Discarded worktree %s for %s\n", branch, cfg.Name)
+	_, err = ws.TgBot.SendMessage(ctx, chatMessage(
+		key,
+		reply,
+	))
+	return err
+}
+
+// sendGitError reports a failed git command to the chat
+func (m *Manager) sendGitError(ctx context.Context, ws *WorkspaceBot, key session.Key, err error) error {
+	_, sendErr := ws.TgBot.SendMessage(ctx, chatMessage(
+		key,
+		fmt.Sprintf("❌ %v", err),
+	))
+	return sendErr
+}
@@ -0,0 +1,69 @@
+package checkpoint
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"telecode/internal/git"
+)
+
+// Kinds of checkpoints
+const (
+	KindRun     = "run"     // Taken before an agent run
+	KindRestore = "restore" // Taken before restoring another checkpoint
+)
+
+// Checkpoint is a snapshot of a working directory
+type Checkpoint struct {
+	ID    int
+	Time  time.Time
+	Kind  string
+	Label string // The prompt of the run, or the restored checkpoint
+}
+
+// Change is a file that a restore reverted
+type Change = git.Change
+
+// Store keeps the checkpoints of one working directory
+type Store interface {
+	// Create snapshots the working directory
+	Create(ctx context.Context, kind, label string) (Checkpoint, error)
+	// List returns the checkpoints, newest first
+	List(ctx context.Context) ([]Checkpoint, error)
+	// Restore makes the working directory match a checkpoint and returns the files it changed
+	Restore(ctx context.Context, id int) ([]Change, error)
+	// Delete deletes a checkpoint
+	Delete(ctx context.Context, id int) error
+	// Prune deletes all but the newest keep checkpoints
+	Prune(ctx context.Context, keep int) error
+}
+
+// Open returns the checkpoint store of workDir under scope (e.g. a chat):
+// hidden refs if workDir is a git repository, otherwise file copies under copyDir
+func Open(ctx context.Context, workDir, copyDir, scope string) Store {
+	if git.IsRepo(ctx, workDir) {
+		return &gitStore{dir: workDir, prefix: "refs/telecode/checkpoints/" + scope + "/"}
+	}
+	return &copyStore{dir: workDir, root: filepath.Join(copyDir, scope)}
+}
+
+// nextID returns the ID following the newest checkpoint
+func nextID(checkpoints []Checkpoint) int {
+	id := 0
+	for _, c := range checkpoints {
+		id = max(id, c.ID)
+	}
+	return id + 1
+}
+
+// find returns the checkpoint with the given ID
+func find(checkpoints []Checkpoint, id int) (Checkpoint, error) {
+	for _, c := range checkpoints {
+		if c.ID == id {
+			return c, nil
+		}
+	}
+	return Checkpoint{}, fmt.Errorf("checkpoint %d not found", id)
+}
@@ -0,0 +1,263 @@
+package checkpoint
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"telecode/internal/git"
+)
+
+// maxCopyBytes is the largest directory snapshotted by copying its files
+const maxCopyBytes = 200 << 20
+
+// skipDirs are dependency and cache directories left out of file-copy
+// snapshots; a restore leaves them alone
+var skipDirs = map[string]bool{
+	".git":         true,
+	"node_modules": true,
+	".venv":        true,
+	"venv":         true,
+	"__pycache__":  true,
+	".cache":       true,
+}
+
+// metaFile describes a file-copy checkpoint next to its files directory
+const metaFile = "checkpoint.json"
+
+// copyMeta is the content of metaFile
+type copyMeta struct {
+	ID    int       `json:"id"`
+	Time  time.Time `json:"time"`
+	Kind  string    `json:"kind"`
+	Label string    `json:"label"`
+}
+
+// copyStore keeps checkpoints of a directory that is not a git repository
+// as copies of its files, in root/<id>/files
+type copyStore struct {
+	dir  string
+	root string
+}
+
+// Create copies the files of the directory
+func (s *copyStore) Create(ctx context.Context, kind, label string) (Checkpoint, error) {
+	checkpoints, err := s.List(ctx)
+	if err != nil {
+		return Checkpoint{}, err
+	}
+	files, err := s.files(s.dir)
+	if err != nil {
+		return Checkpoint{}, err
+	}
+	var size int64
+	for _, info := range files {
+		size += info.Size()
+	}
+	if size > maxCopyBytes {
+		return Checkpoint{}, fmt.Errorf("%s is too large for a file-copy checkpoint (%d MB)", s.dir, size>>20)
+	}
+
+	c := Checkpoint{ID: nextID(checkpoints), Time: time.Now(), Kind: kind, Label: label}
+	tmp := filepath.Join(s.root, "."+strconv.Itoa(c.ID)+".tmp")
+	if err := os.RemoveAll(tmp); err != nil {
+		return Checkpoint{}, err
+	}
+	for path, info := range files {
+		if err := ctx.Err(); err != nil {
+			os.RemoveAll(tmp)
+			return Checkpoint{}, err
+		}
+		if err := copyFile(filepath.Join(s.dir, path), filepath.Join(tmp, "files", path), info); err != nil {
+			os.RemoveAll(tmp)
+			return Checkpoint{}, err
+		}
+	}
+
+	meta, err := json.Marshal(copyMeta{ID: c.ID, Time: c.Time, Kind: kind, Label: label})
+	if err == nil {
+		err = os.WriteFile(filepath.Join(tmp, metaFile), meta, 0600)
+	}
+	if err == nil {
+		err = os.Rename(tmp, filepath.Join(s.root, strconv.Itoa(c.ID)))
+	}
+	if err != nil {
+		os.RemoveAll(tmp)
+		return Checkpoint{}, err
+	}
+	return c, nil
+}
+
+// List returns the checkpoints, newest first
+func (s *copyStore) List(ctx context.Context) ([]Checkpoint, error) {
+	entries, err := os.ReadDir(s.root)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var checkpoints []Checkpoint
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(s.root, entry.Name(), metaFile))
+		if err != nil {
+			continue
+		}
+		var meta copyMeta
+		if err := json.Unmarshal(data, &meta); err != nil {
+			continue
+		}
+		checkpoints = append(checkpoints, Checkpoint(meta))
+	}
+	slices.SortFunc(checkpoints, func(a, b Checkpoint) int { return cmp.Compare(b.ID, a.ID) })
+	return checkpoints, nil
+}
+
+// Restore copies back files that differ from the checkpoint and deletes files
+// created since, with the directories they leave empty
+func (s *copyStore) Restore(ctx context.Context, id int) ([]Change, error) {
+	checkpoints, err := s.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := find(checkpoints, id); err != nil {
+		return nil, err
+	}
+
+	snapshotDir := filepath.Join(s.root, strconv.Itoa(id), "files")
+	saved, err := s.files(snapshotDir)
+	if err != nil {
+		return nil, err
+	}
+	current, err := s.files(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	for path, info := range saved {
+		status := byte('M')
+		if currentInfo, ok := current[path]; !ok {
+			status = 'A'
+		} else if sameFile(filepath.Join(snapshotDir, path), filepath.Join(s.dir, path), info, currentInfo) {
+			continue
+		}
+		if err := copyFile(filepath.Join(snapshotDir, path), filepath.Join(s.dir, path), info); err != nil {
+			return changes, err
+		}
+		changes = append(changes, Change{Status: status, Path: path})
+	}
+	for path := range current {
+		if _, ok := saved[path]; !ok {
+			if err := git.RemoveFile(s.dir, path); err != nil {
+				return changes, err
+			}
+			changes = append(changes, Change{Status: 'D', Path: path})
+		}
+	}
+	slices.SortFunc(changes, func(a, b Change) int { return strings.Compare(a.Path, b.Path) })
+	return changes, nil
+}
+
+// Prune deletes all but the newest keep checkpoints
+func (s *copyStore) Prune(ctx context.Context, keep int) error {
+	checkpoints, err := s.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, c := range checkpoints[min(keep, len(checkpoints)):] {
+		if err := s.Delete(ctx, c.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes the copy of a checkpoint
+func (s *copyStore) Delete(ctx context.Context, id int) error {
+	return os.RemoveAll(filepath.Join(s.root, strconv.Itoa(id)))
+}
+
+// files returns the regular files and symlinks under dir by relative path,
+// skipping skipDirs and the checkpoint root if it is inside dir
+func (s *copyStore) files(dir string) (map[string]fs.FileInfo, error) {
+	files := make(map[string]fs.FileInfo)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != dir && (skipDirs[d.Name()] || path == s.root) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() && d.Type()&fs.ModeSymlink == 0 {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[rel] = info
+		return nil
+	})
+	return files, err
+}
+
+// copyFile copies a regular file or symlink, creating parent directories
+func copyFile(src, dst string, info fs.FileInfo) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(dst); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return os.Symlink(target, dst)
+	}
+
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(dst, data, info.Mode().Perm()); err != nil {
+		return err
+	}
+	// WriteFile keeps the mode of an existing file
+	return os.Chmod(dst, info.Mode().Perm())
+}
+
+// sameFile reports whether two files have the same type, mode and content
+func sameFile(a, b string, infoA, infoB fs.FileInfo) bool {
+	if infoA.Mode() != infoB.Mode() || infoA.Size() != infoB.Size() {
+		return false
+	}
+	if infoA.Mode()&fs.ModeSymlink != 0 {
+		targetA, errA := os.Readlink(a)
+		targetB, errB := os.Readlink(b)
+		return errA == nil && errB == nil && targetA == targetB
+	}
+	dataA, errA := os.ReadFile(a)
+	dataB, errB := os.ReadFile(b)
+	return errA == nil && errB == nil && bytes.Equal(dataA, dataB)
+}
@@ -0,0 +1,75 @@
+package checkpoint
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func writeFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCopyStoreRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store := Open(ctx, dir, t.TempDir(), "chat1")
+	if _, ok := store.(*copyStore); !ok {
+		t.Fatalf("Open returned %T for a plain directory, want *copyStore", store)
+	}
+
+	writeFile(t, dir, "modified.txt", "before\n")
+	writeFile(t, dir, "deleted.txt", "deleted\n")
+	writeFile(t, dir, "node_modules/dep/index.js", "before\n")
+	c, err := store.Create(ctx, KindRun, "prompt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, dir, "modified.txt", "after\n")
+	writeFile(t, dir, "node_modules/dep/index.js", "after\n")
+	writeFile(t, dir, "new/deep/added.txt", "created\n")
+	if err := os.Remove(filepath.Join(dir, "deleted.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := store.Restore(ctx, c.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Change{
+		{Status: 'A', Path: "deleted.txt"},
+		{Status: 'M', Path: "modified.txt"},
+		{Status: 'D', Path: filepath.Join("new", "deep", "added.txt")},
+	}
+	if !slices.Equal(changes, want) {
+		t.Errorf("changes = %+v, want %+v", changes, want)
+	}
+
+	for name, content := range map[string]string{
+		"modified.txt":              "before\n",
+		"deleted.txt":               "deleted\n",
+		"node_modules/dep/index.js": "after\n", // Skipped directories are left alone
+	} {
+		if data, err := os.ReadFile(filepath.Join(dir, name)); err != nil || string(data) != content {
+			t.Errorf("%s = %q (%v), want %q", name, data, err, content)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "new")); !os.IsNotExist(err) {
+		t.Errorf("directory of the deleted file still exists: %v", err)
+	}
+
+	// Restoring again changes nothing
+	if changes, err := store.Restore(ctx, c.ID); err != nil || len(changes) != 0 {
+		t.Errorf("second restore = %+v, %v, want no changes", changes, err)
+	}
+}
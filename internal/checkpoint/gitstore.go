@@ -0,0 +1,89 @@
+package checkpoint
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"telecode/internal/git"
+)
+
+// subjectPrefix starts the commit message of checkpoint commits, followed by "<kind>: <label>"
+const subjectPrefix = "telecode "
+
+// gitStore keeps checkpoints as commits on hidden refs (prefix + ID), outside
+// the branches so they never show up in the history
+type gitStore struct {
+	dir    string
+	prefix string
+}
+
+// Create snapshots the working tree, untracked files included
+func (s *gitStore) Create(ctx context.Context, kind, label string) (Checkpoint, error) {
+	checkpoints, err := s.List(ctx)
+	if err != nil {
+		return Checkpoint{}, err
+	}
+
+	c := Checkpoint{ID: nextID(checkpoints), Time: time.Now(), Kind: kind, Label: label}
+	message := fmt.Sprintf("%s%s: %s", subjectPrefix, kind, strings.Join(strings.Fields(label), " "))
+	if err := git.Snapshot(ctx, s.dir, s.prefix+strconv.Itoa(c.ID), message); err != nil {
+		return Checkpoint{}, err
+	}
+	return c, nil
+}
+
+// List returns the checkpoints, newest first
+func (s *gitStore) List(ctx context.Context) ([]Checkpoint, error) {
+	refs, err := git.ListRefs(ctx, s.dir, s.prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var checkpoints []Checkpoint
+	for _, ref := range refs {
+		id, err := strconv.Atoi(strings.TrimPrefix(ref.Name, s.prefix))
+		if err != nil {
+			continue
+		}
+		kind, label, _ := strings.Cut(strings.TrimPrefix(ref.Subject, subjectPrefix), ": ")
+		checkpoints = append(checkpoints, Checkpoint{ID: id, Time: ref.Time, Kind: kind, Label: label})
+	}
+	slices.SortFunc(checkpoints, func(a, b Checkpoint) int { return cmp.Compare(b.ID, a.ID) })
+	return checkpoints, nil
+}
+
+// Restore checks out the files of a checkpoint and deletes files created since
+func (s *gitStore) Restore(ctx context.Context, id int) ([]Change, error) {
+	checkpoints, err := s.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := find(checkpoints, id); err != nil {
+		return nil, err
+	}
+	return git.RestoreSnapshot(ctx, s.dir, s.prefix+strconv.Itoa(id))
+}
+
+// Prune deletes the refs of all but the newest keep checkpoints
+func (s *gitStore) Prune(ctx context.Context, keep int) error {
+	checkpoints, err := s.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, c := range checkpoints[min(keep, len(checkpoints)):] {
+		if err := s.Delete(ctx, c.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete deletes the ref of a checkpoint
+func (s *gitStore) Delete(ctx context.Context, id int) error {
+	return git.DeleteRef(ctx, s.dir, s.prefix+strconv.Itoa(id))
+}
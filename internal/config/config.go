@@ -3,6 +3,9 @@ package config
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -14,13 +17,190 @@ type WorkspaceConfig struct {
 	WorkingDir     string        `yaml:"working_dir"`
 	BotToken       string        `yaml:"bot_token"`
 	AllowedChats   []int64       `yaml:"allowed_chats,omitempty"`
+	AllowedUsers   []UserRule    `yaml:"allowed_users,omitempty"`
 	DefaultCLI     string        `yaml:"default_cli,omitempty"`
 	CommandTimeout time.Duration `yaml:"command_timeout,omitempty"`
-	Model          string        `yaml:"model,omitempty"`
+	SessionStore   string        `yaml:"session_store,omitempty"`
+
+	// Model is the default model of OpenCode, in provider/model format
+	Model string `yaml:"model,omitempty"`
+
+	// DefaultModels is the default model of each CLI, e.g. {"claude": "sonnet"};
+	// CLIs without one use their own default
+	DefaultModels map[string]string `yaml:"default_models,omitempty"`
+
+	// Models lists the models each CLI may be switched to with /model,
+	// e.g. {"claude": ["sonnet", "opus"]}
+	Models map[string][]string `yaml:"models,omitempty"`
+
+	// DefaultRole is the role of users in allowed chats that are not listed in
+	// AllowedUsers; "none" ignores them. Defaults to "admin" without AllowedUsers
+	// (chat-only allowlist), otherwise to "none".
+	DefaultRole string `yaml:"default_role,omitempty"`
+
+	MaxConcurrentRuns int `yaml:"max_concurrent_runs,omitempty"`
+	MaxQueueSize      int `yaml:"max_queue_size,omitempty"`
+
+	// DocumentThreshold is the response length (in characters) above which
+	// the response is sent as a document instead of text messages
+	DocumentThreshold int `yaml:"document_threshold,omitempty"`
+
+	// InboxDir keeps received files (relative to WorkingDir unless absolute);
+	// when empty they are stored in a temp directory and removed after the run
+	InboxDir      string `yaml:"inbox_dir,omitempty"`
+	MaxFileSizeMB int    `yaml:"max_file_size_mb,omitempty"`
+
+	// TranscribeCommand converts a voice message into text on stdout;
+	// "{file}" is replaced by the audio file path
+	TranscribeCommand []string `yaml:"transcribe_command,omitempty"`
+
+	// RequireMention makes the bot answer prompts in group chats only when it
+	// is mentioned (@botname) or a message of the bot is replied to
+	RequireMention bool `yaml:"require_mention,omitempty"`
+
+	// Budget limits the tokens and costs of the workspace and of each user
+	Budget BudgetConfig `yaml:"budget,omitempty"`
+
+	// Checkpoints is the number of working tree snapshots kept per chat
+	// for /undo and /restore; -1 disables them
+	Checkpoints int `yaml:"checkpoints,omitempty"`
+
+	// CheckpointDir holds the file-copy checkpoints of a working directory
+	// that is not a git repository; defaults to ~/.telecode/checkpoints/<name>
+	CheckpointDir string `yaml:"checkpoint_dir,omitempty"`
+
+	// Git sets the identity of commits made by Telecode and where /commit pushes
+	Git GitConfig `yaml:"git,omitempty"`
+
+	// Isolation set to "worktree" runs every session in its own git worktree
+	// on a telecode/... branch, so parallel chats never edit the same checkout
+	Isolation string `yaml:"isolation,omitempty"`
+
+	// WorktreeDir holds the session worktrees (relative to WorkingDir unless
+	// absolute); defaults to ~/.telecode/worktrees/<name>
+	WorktreeDir string `yaml:"worktree_dir,omitempty"`
+
+	// Updates selects how updates are received: "polling" or "webhook"
+	// (defaults to the global setting)
+	Updates string `yaml:"updates,omitempty"`
+
+	// WebhookSecret is sent by Telegram with every webhook request;
+	// a random one is generated at startup if empty
+	WebhookSecret string `yaml:"webhook_secret,omitempty"`
+}
+
+// GitConfig configures the commits made by /commit and /merge
+type GitConfig struct {
+	// AuthorName and AuthorEmail are the author and committer; defaults to
+	// the user configured in git on the server
+	AuthorName  string `yaml:"author_name,omitempty"`
+	AuthorEmail string `yaml:"author_email,omitempty"`
+
+	// Remote, if set, is pushed to after /commit, e.g. "origin"
+	Remote string `yaml:"remote,omitempty"`
+
+	// Branch is the remote branch pushed to; defaults to the current branch
+	Branch string `yaml:"branch,omitempty"`
+}
+
+// BudgetConfig limits spending; prompts are refused once a limit is reached
+type BudgetConfig struct {
+	Workspace BudgetLimits `yaml:"workspace,omitempty"`
+	User      BudgetLimits `yaml:"user,omitempty"` // Applies to every user separately
+
+	// WarnAt lists the fractions of a limit (e.g. 0.8) at which the chat is warned
+	WarnAt []float64 `yaml:"warn_at,omitempty"`
+}
+
+// BudgetLimits are daily and monthly limits; zero means unlimited
+type BudgetLimits struct {
+	DailyCost     float64 `yaml:"daily_cost,omitempty"` // US dollars
+	MonthlyCost   float64 `yaml:"monthly_cost,omitempty"`
+	DailyTokens   int64   `yaml:"daily_tokens,omitempty"`
+	MonthlyTokens int64   `yaml:"monthly_tokens,omitempty"`
+}
+
+// UserRule grants a Telegram user a role ("admin", "operator" or "read_only")
+type UserRule struct {
+	ID   int64  `yaml:"id"`
+	Role string `yaml:"role"`
+
+	// AnyChat grants the role in every chat, including chats not listed in
+	// AllowedChats; otherwise the role applies in allowed chats only
+	AnyChat bool `yaml:"any_chat,omitempty"`
+}
+
+// Roles lists the valid user roles, from most to least privileged
+var Roles = []string{"admin", "operator", "read_only"}
+
+// WebhookConfig configures the embedded HTTP server used in webhook mode
+type WebhookConfig struct {
+	// Listen is the address of the HTTP server, e.g. ":8080"
+	Listen string `yaml:"listen,omitempty"`
+
+	// URL is the public base URL Telegram posts to, e.g. "https://bots.example.com";
+	// each workspace is served at <url>/webhook/<name>
+	URL string `yaml:"url,omitempty"`
+}
+
+// CustomCLIConfig declares an agent CLI that has no built-in executor.
+// Arguments may contain the placeholders {prompt}, {session}, {file} and {model}.
+type CustomCLIConfig struct {
+	// Name selects the CLI in default_cli and /cli
+	Name string `yaml:"name"`
+
+	// Command is run for every prompt and must contain {prompt},
+	// e.g. ["mycli", "run", "--json", "{prompt}"]
+	Command []string `yaml:"command"`
+
+	// SessionArgs are appended when a session is resumed, e.g. ["--resume", "{session}"]
+	SessionArgs []string `yaml:"session_args,omitempty"`
+
+	// FileArgs are appended once per attached file, e.g. ["--file", "{file}"]
+	FileArgs []string `yaml:"file_args,omitempty"`
+
+	// ModelArgs are appended when a model is set, e.g. ["--model", "{model}"]
+	ModelArgs []string `yaml:"model_args,omitempty"`
+
+	// ReadOnlyArgs are appended for read-only users; without them
+	// read-only users cannot run prompts with this CLI
+	ReadOnlyArgs []string `yaml:"read_only_args,omitempty"`
+
+	// SessionID finds the session ID to resume in the output
+	SessionID ExtractRule `yaml:"session_id,omitempty"`
+
+	// Output finds the response text in the output; the whole output is shown without it
+	Output ExtractRule `yaml:"output,omitempty"`
+
+	// Stats is run for /stats
+	Stats []string `yaml:"stats,omitempty"`
+}
+
+// ExtractRule finds values in CLI output, either with a regular expression
+// (its first group, or the whole match) or with a dotted JSON path such as
+// "result.session_id" applied to the output or to each JSON line
+type ExtractRule struct {
+	Regex    string `yaml:"regex,omitempty"`
+	JSONPath string `yaml:"json_path,omitempty"`
 }
 
 // Config represents the complete telecode configuration
 type Config struct {
+	// Updates is the default update mode for all workspaces ("polling" or "webhook")
+	Updates string        `yaml:"updates,omitempty"`
+	Webhook WebhookConfig `yaml:"webhook,omitempty"`
+
+	// DrainTimeout is how long running prompts may take to finish on shutdown
+	// before they are cancelled
+	DrainTimeout time.Duration `yaml:"drain_timeout,omitempty"`
+
+	// UsageStore is the JSON file where token usage and costs are recorded;
+	// "" keeps them in memory only
+	UsageStore string `yaml:"usage_store,omitempty"`
+
+	// CustomCLIs are agent CLIs available to all workspaces besides the built-in ones
+	CustomCLIs []CustomCLIConfig `yaml:"custom_clis,omitempty"`
+
 	Workspaces []WorkspaceConfig `yaml:"workspaces"`
 }
 
@@ -36,14 +216,120 @@ func LoadConfig(path string) (*Config, error) {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
 
+	if cfg.Updates == "" {
+		cfg.Updates = "polling"
+	}
+	if cfg.Webhook.Listen == "" {
+		cfg.Webhook.Listen = ":8080"
+	}
+	if cfg.DrainTimeout == 0 {
+		cfg.DrainTimeout = 2 * time.Minute
+	}
+	if cfg.UsageStore == "" {
+		cfg.UsageStore = defaultUsageStore()
+	}
+
+	if err := validateCustomCLIs(cfg.CustomCLIs); err != nil {
+		return nil, err
+	}
+
 	// Set defaults and validate
+	names := make(map[string]bool)
 	for i := range cfg.Workspaces {
+		// Workspaces are matched by name when the config is reloaded
+		if names[cfg.Workspaces[i].Name] {
+			return nil, fmt.Errorf("workspace %d: duplicate name '%s'", i, cfg.Workspaces[i].Name)
+		}
+		names[cfg.Workspaces[i].Name] = true
+
+		if cfg.Workspaces[i].Updates == "" {
+			cfg.Workspaces[i].Updates = cfg.Updates
+		}
+		switch cfg.Workspaces[i].Updates {
+		case "polling":
+		case "webhook":
+			if cfg.Webhook.URL == "" {
+				return nil, fmt.Errorf("workspace %d: webhook.url is required for webhook updates", i)
+			}
+		default:
+			return nil, fmt.Errorf("workspace %d: unknown updates mode '%s' (use polling or webhook)", i, cfg.Workspaces[i].Updates)
+		}
 		if cfg.Workspaces[i].DefaultCLI == "" {
 			cfg.Workspaces[i].DefaultCLI = "claude"
 		}
 		if cfg.Workspaces[i].CommandTimeout == 0 {
 			cfg.Workspaces[i].CommandTimeout = 20 * time.Minute
 		}
+		if cfg.Workspaces[i].MaxConcurrentRuns <= 0 {
+			cfg.Workspaces[i].MaxConcurrentRuns = 4
+		}
+		if cfg.Workspaces[i].MaxQueueSize <= 0 {
+			cfg.Workspaces[i].MaxQueueSize = 10
+		}
+		if cfg.Workspaces[i].DocumentThreshold == 0 {
+			cfg.Workspaces[i].DocumentThreshold = 12000
+		}
+		if cfg.Workspaces[i].MaxFileSizeMB <= 0 {
+			cfg.Workspaces[i].MaxFileSizeMB = 20
+		}
+		if cfg.Workspaces[i].DefaultRole == "" {
+			cfg.Workspaces[i].DefaultRole = "none"
+			if len(cfg.Workspaces[i].AllowedUsers) == 0 {
+				cfg.Workspaces[i].DefaultRole = "admin"
+			}
+		}
+		if cfg.Workspaces[i].DefaultRole != "none" && !validRole(cfg.Workspaces[i].DefaultRole) {
+			return nil, fmt.Errorf("workspace %d: unknown default_role '%s'", i, cfg.Workspaces[i].DefaultRole)
+		}
+		for _, user := range cfg.Workspaces[i].AllowedUsers {
+			if !validRole(user.Role) {
+				return nil, fmt.Errorf("workspace %d: unknown role '%s' for user %d", i, user.Role, user.ID)
+			}
+		}
+		for cli, models := range cfg.Workspaces[i].Models {
+			for _, model := range models {
+				if model == "" || strings.ContainsAny(model, " \t\n") {
+					return nil, fmt.Errorf("workspace %d: invalid model '%s' for %s", i, model, cli)
+				}
+			}
+		}
+		for cli, model := range cfg.Workspaces[i].DefaultModels {
+			if model == "" || strings.ContainsAny(model, " \t\n") {
+				return nil, fmt.Errorf("workspace %d: invalid default model '%s' for %s", i, model, cli)
+			}
+		}
+		if len(cfg.Workspaces[i].Budget.WarnAt) == 0 {
+			cfg.Workspaces[i].Budget.WarnAt = []float64{0.8}
+		}
+		for _, fraction := range cfg.Workspaces[i].Budget.WarnAt {
+			if fraction <= 0 || fraction >= 1 {
+				return nil, fmt.Errorf("workspace %d: budget warn_at %v must be between 0 and 1", i, fraction)
+			}
+		}
+		if cfg.Workspaces[i].Checkpoints == 0 {
+			cfg.Workspaces[i].Checkpoints = 20
+		}
+		if cfg.Workspaces[i].CheckpointDir == "" {
+			cfg.Workspaces[i].CheckpointDir = defaultCheckpointDir(cfg.Workspaces[i].Name)
+		}
+		if git := cfg.Workspaces[i].Git; (git.AuthorName == "") != (git.AuthorEmail == "") {
+			return nil, fmt.Errorf("workspace %d: git author_name and author_email must be set together", i)
+		}
+		if git := cfg.Workspaces[i].Git; git.Branch != "" && git.Remote == "" {
+			return nil, fmt.Errorf("workspace %d: git branch needs a remote", i)
+		}
+		switch cfg.Workspaces[i].Isolation {
+		case "":
+		case "worktree":
+			if cfg.Workspaces[i].WorktreeDir == "" {
+				cfg.Workspaces[i].WorktreeDir = defaultWorktreeDir(cfg.Workspaces[i].Name)
+			}
+		default:
+			return nil, fmt.Errorf("workspace %d: unknown isolation '%s' (use worktree or leave it empty)", i, cfg.Workspaces[i].Isolation)
+		}
+		if cfg.Workspaces[i].SessionStore == "" {
+			cfg.Workspaces[i].SessionStore = defaultSessionStore(cfg.Workspaces[i].Name)
+		}
 		if cfg.Workspaces[i].WorkingDir == "" {
 			return nil, fmt.Errorf("workspace %d: working_dir is required", i)
 		}
@@ -55,6 +341,81 @@ func LoadConfig(path string) (*Config, error) {
 	return &cfg, nil
 }
 
+// validateCustomCLIs checks the custom CLI declarations
+func validateCustomCLIs(clis []CustomCLIConfig) error {
+	names := make(map[string]bool)
+	for i, cli := range clis {
+		if cli.Name == "" || strings.ContainsAny(cli.Name, " \t\n") {
+			return fmt.Errorf("custom CLI %d: name is required and may not contain spaces", i)
+		}
+		if names[cli.Name] {
+			return fmt.Errorf("custom CLI %d: duplicate name '%s'", i, cli.Name)
+		}
+		names[cli.Name] = true
+
+		if len(cli.Command) == 0 || !strings.Contains(strings.Join(cli.Command, " "), "{prompt}") {
+			return fmt.Errorf("custom CLI %s: command is required and must contain {prompt}", cli.Name)
+		}
+		for field, rule := range map[string]ExtractRule{"session_id": cli.SessionID, "output": cli.Output} {
+			if rule.Regex != "" && rule.JSONPath != "" {
+				return fmt.Errorf("custom CLI %s: %s has both regex and json_path", cli.Name, field)
+			}
+			if _, err := regexp.Compile(rule.Regex); err != nil {
+				return fmt.Errorf("custom CLI %s: invalid %s regex: %w", cli.Name, field, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validRole reports whether role is one of Roles
+func validRole(role string) bool {
+	for _, r := range Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultSessionStore returns the default session store path for a workspace,
+// or "" (in-memory only) if it cannot be determined
+func defaultSessionStore(name string) string {
+	home, err := os.UserHomeDir()
+	if err != nil || name == "" {
+		return ""
+	}
+	return filepath.Join(home, ".telecode", "sessions", name+".json")
+}
+
+// defaultCheckpointDir returns the default directory of the file-copy checkpoints of a workspace
+func defaultCheckpointDir(name string) string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "telecode", "checkpoints", name)
+	}
+	return filepath.Join(home, ".telecode", "checkpoints", name)
+}
+
+// defaultWorktreeDir returns the default directory of the session worktrees of a workspace
+func defaultWorktreeDir(name string) string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".telecode", "worktrees", name)
+	}
+	return filepath.Join(home, ".telecode", "worktrees", name)
+}
+
+// defaultUsageStore returns the default usage ledger path,
+// or "" (in-memory only) if it cannot be determined
+func defaultUsageStore() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".telecode", "usage.json")
+}
+
 // GetDefaultConfigPath returns the default configuration file path
 func GetDefaultConfigPath() string {
 	// Check for config in home directory
@@ -84,15 +445,62 @@ func CreateExampleConfig(path string) error {
 	example := `# Telecode Multi-Bot Configuration
 # Each workspace represents a separate project with its own bot
 
+# drain_timeout: 2m  # Optional: how long running prompts may finish on shutdown
+# usage_store: /home/user/.telecode/usage.json  # Optional: where token usage and costs are recorded
+# updates: webhook  # Optional: receive updates via webhook instead of long polling
+# webhook:
+#   listen: ":8080"
+#   url: "https://bots.example.com"  # Public URL of your reverse proxy
+
+# custom_clis:  # Optional: agent CLIs without a built-in executor, selectable with /cli
+#   - name: mycli
+#     command: ["mycli", "run", "--json", "{prompt}"]
+#     session_args: ["--resume", "{session}"]
+#     file_args: ["--file", "{file}"]
+#     model_args: ["--model", "{model}"]
+#     read_only_args: ["--read-only"]
+#     session_id: {json_path: "session_id"}   # or {regex: 'session: (\S+)'}
+#     output: {json_path: "result"}
+
 workspaces:
   - name: project-a
     working_dir: /home/user/project-a
     bot_token: "YOUR_BOT_TOKEN_1"
     allowed_chats:
       - 123456789
+    # allowed_users:  # Optional: per-user roles (admin, operator, read_only)
+    #   - id: 123456789
+    #     role: admin
+    #     any_chat: true  # Optional: also in chats not listed in allowed_chats
+    # default_role: read_only  # Optional: role of other members of allowed chats
+    # require_mention: true    # Optional: in groups, only answer when mentioned or replied to
     default_cli: opencode
     command_timeout: 20m
-    # model: anthropic/opus-4.6  # Optional: OpenCode model (defaults to opus-4.6)
+    # model: anthropic/opus-4.6  # Optional: default model of OpenCode (defaults to opus-4.6)
+    # default_models:  # Optional: default model of each CLI, otherwise the CLI's own default
+    #   claude: sonnet
+    # models:  # Optional: models each CLI may be switched to with /model
+    #   claude: [sonnet, opus]
+    #   opencode: [anthropic/opus-4.6, openai/gpt-5]
+    # session_store: /home/user/.telecode/sessions/project-a.json  # Optional: where sessions are persisted
+    # max_concurrent_runs: 4  # Optional: agent runs in parallel across chats
+    # max_queue_size: 10      # Optional: pending prompts per chat
+    # document_threshold: 12000  # Optional: send longer responses as a file (-1 disables)
+    # inbox_dir: .telecode/inbox  # Optional: keep received files here (relative to working_dir)
+    # max_file_size_mb: 20        # Optional: largest file accepted from Telegram
+    # transcribe_command: ["/usr/local/bin/transcribe.sh", "{file}"]  # Optional: speech-to-text for voice messages
+    # git:  # Optional: identity of commits made by /commit and /merge, and where /commit pushes
+    #   author_name: Telecode Bot
+    #   author_email: bot@example.com
+    #   remote: origin
+    #   branch: main  # Defaults to the current branch
+    # checkpoints: 20  # Optional: snapshots kept per chat for /undo and /restore (-1 disables)
+    # isolation: worktree  # Optional: run each session in its own git worktree (/merge, /discard, /worktrees)
+    # worktree_dir: /home/user/.telecode/worktrees/project-a  # Optional: where session worktrees are created
+    # budget:  # Optional: refuse prompts once a limit is reached (/budget override lifts it for the day)
+    #   workspace: {daily_cost: 20, monthly_cost: 300}
+    #   user: {daily_cost: 5, daily_tokens: 2000000}
+    #   warn_at: [0.5, 0.8]
 
   - name: project-b
     working_dir: /home/user/project-b
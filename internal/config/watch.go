@@ -0,0 +1,49 @@
+package config
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// watchInterval is how often the config file is checked for changes
+const watchInterval = 2 * time.Second
+
+// Watch reports changes of the file at path (modification time or size)
+// until ctx is done. Checking by polling avoids a dependency on
+// platform-specific file notification APIs.
+func Watch(ctx context.Context, path string) <-chan struct{} {
+	changes := make(chan struct{}, 1)
+
+	go func() {
+		last, _ := os.Stat(path)
+
+		ticker := time.NewTicker(watchInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			info, err := os.Stat(path)
+			if err != nil {
+				// Editors may replace the file; wait until it is back
+				continue
+			}
+			if last != nil && info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size() {
+				continue
+			}
+			last = info
+
+			select {
+			case changes <- struct{}{}:
+			default: // A reload is already pending
+			}
+		}
+	}()
+
+	return changes
+}
@@ -0,0 +1,144 @@
+package executor
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// aiderCommitRe matches the line Aider prints for every commit it makes
+var aiderCommitRe = regexp.MustCompile(`(?m)^Commit ([0-9a-f]{7,40}) (.+)$`)
+
+// aiderTokensRe matches Aider's token report after every model call,
+// e.g. "Tokens: 12k sent, 3.2k cache write, 1.1k cache hit, 150 received."
+var aiderTokensRe = regexp.MustCompile(`(?m)^Tokens: ([\d.]+[kM]?) sent(?:, ([\d.]+[kM]?) cache write)?(?:, ([\d.]+[kM]?) cache hit)?, ([\d.]+[kM]?) received\.`)
+
+// aiderCostRe matches the cost Aider reports after every model call
+var aiderCostRe = regexp.MustCompile(`(?m)Cost: \$([\d.]+) message`)
+
+// aiderUnsafeChars matches characters not allowed in history file names
+var aiderUnsafeChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// aiderNoiseRe matches Aider status lines that are not part of the response
+var aiderNoiseRe = regexp.MustCompile(`^(Aider v|Main model:|Weak model:|Editor model:|Git repo:|Repo-map:|` +
+	`Added .+ to the chat|Restored previous conversation history|Tokens:|Cost:|Use /help|https://aider\.chat|` +
+	`Applied edit to |Commit [0-9a-f]{7,40} )`)
+
+// AiderExecutor implements Executor for Aider. Aider has no session IDs, so
+// telecode assigns them and keeps each session's chat history in its own
+// files in the working directory.
+type AiderExecutor struct{}
+
+// BuildCommand builds the Aider command
+func (e *AiderExecutor) BuildCommand(prompt, sessionID string, files []string, model string, readOnly bool) []string {
+	// The "=" form keeps a prompt starting with "-" from being taken for a flag
+	cmd := []string{"aider", "--message=" + prompt, "--yes-always", "--no-pretty", "--no-check-update"}
+
+	if sessionID != "" {
+		history := ".aider.telecode-" + aiderUnsafeChars.ReplaceAllString(sessionID, "_")
+		cmd = append(cmd,
+			"--chat-history-file", history+".chat.history.md",
+			"--input-history-file", history+".input.history",
+			"--restore-chat-history",
+		)
+	}
+
+	if model != "" {
+		cmd = append(cmd, "--model", model)
+	}
+
+	// Ask mode answers questions about the code without editing it
+	if readOnly {
+		cmd = append(cmd, "--chat-mode", "ask", "--no-auto-commits")
+	}
+
+	for _, file := range files {
+		cmd = append(cmd, "--file", file)
+	}
+
+	return cmd
+}
+
+// NewSessionID returns a new ID naming the chat history files of a session
+func (e *AiderExecutor) NewSessionID() string {
+	b := make([]byte, 3)
+	_, _ = rand.Read(b)
+	return time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(b)
+}
+
+// ParseSessionID returns "" because Aider does not report session IDs
+func (e *AiderExecutor) ParseSessionID(output string) string {
+	return ""
+}
+
+// ExtractOutput drops Aider's status lines and lists the commits it made
+func (e *AiderExecutor) ExtractOutput(output string) string {
+	var lines []string
+	for _, line := range strings.Split(output, "\n") {
+		if !aiderNoiseRe.MatchString(line) {
+			lines = append(lines, line)
+		}
+	}
+	text := strings.TrimSpace(strings.Join(lines, "\n"))
+
+	commits := aiderCommitRe.FindAllStringSubmatch(output, -1)
+	if len(commits) == 0 {
+		return text
+	}
+	var sb strings.Builder
+	sb.WriteString(text)
+	fmt.Fprintf(&sb, "\n\n🔖 Aider made %d commit(s):\n", len(commits))
+	for _, c := range commits {
+		fmt.Fprintf(&sb, "- `%s` %s\n", c[1], c[2])
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+// ParseUsage sums the rounded token counts and costs Aider prints
+func (e *AiderExecutor) ParseUsage(output string) Usage {
+	var usage Usage
+	for _, m := range aiderTokensRe.FindAllStringSubmatch(output, -1) {
+		usage.InputTokens += parseAiderCount(m[1])
+		usage.CacheWriteTokens += parseAiderCount(m[2])
+		usage.CacheReadTokens += parseAiderCount(m[3])
+		usage.OutputTokens += parseAiderCount(m[4])
+	}
+	for _, m := range aiderCostRe.FindAllStringSubmatch(output, -1) {
+		cost, _ := strconv.ParseFloat(m[1], 64)
+		usage.CostUSD += cost
+	}
+	return usage
+}
+
+// parseAiderCount parses a token count such as "150", "2.3k" or "1.2M"
+func parseAiderCount(s string) int64 {
+	multiplier := 1.0
+	switch {
+	case strings.HasSuffix(s, "k"):
+		multiplier, s = 1e3, strings.TrimSuffix(s, "k")
+	case strings.HasSuffix(s, "M"):
+		multiplier, s = 1e6, strings.TrimSuffix(s, "M")
+	}
+	n, _ := strconv.ParseFloat(s, 64)
+	return int64(n * multiplier)
+}
+
+// Name returns the Executor name
+func (e *AiderExecutor) Name() string {
+	return "aider"
+}
+
+// Stats returns statistics information
+func (e *AiderExecutor) Stats() (string, error) {
+	// Aider has no stats command, just check installation
+	_, err := exec.LookPath("aider")
+	if err != nil {
+		return "Aider is not installed", nil
+	}
+	return "Aider is installed", nil
+}
@@ -0,0 +1,97 @@
+package executor
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestAiderExtractOutput(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{
+			name:   "edits and commits",
+			output: readSample(t, "aider.txt"),
+			want: "The loop never ends because `i` is not incremented.\n\n" +
+				"main.go\n```go\n<<<<<<< SEARCH\n\tfor i := 0; i < n; {\n=======\n\tfor i := 0; i < n; i++ {\n>>>>>>> REPLACE\n```\n\n" +
+				"🔖 Aider made 2 commit(s):\n" +
+				"- `3f9a2b1` fix: Increment loop counter in main\n" +
+				"- `a1b2c3d4` docs: Explain loop bound",
+		},
+		{
+			name:   "answer without commits",
+			output: "Aider v0.86.1\nMain model: gpt-5\n\nIt returns an error.\n\nTokens: 900 sent, 40 received.\n",
+			want:   "It returns an error.",
+		},
+		{
+			name: "empty output",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (&AiderExecutor{}).ExtractOutput(tt.output); got != tt.want {
+				t.Errorf("ExtractOutput() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAiderParseUsage(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   Usage
+	}{
+		{
+			name:   "two model calls with cache",
+			output: readSample(t, "aider.txt"),
+			want: Usage{
+				InputTokens:      14500,
+				OutputTokens:     235,
+				CacheWriteTokens: 3200,
+				CacheReadTokens:  1100,
+				CostUSD:          0.0481,
+			},
+		},
+		{
+			name:   "millions",
+			output: "Tokens: 1.2M sent, 3k received. Cost: $1.50 message, $1.50 session.",
+			want:   Usage{InputTokens: 1200000, OutputTokens: 3000, CostUSD: 1.5},
+		},
+		{
+			name:   "no report",
+			output: "Aider v0.86.1\nhello",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := (&AiderExecutor{}).ParseUsage(tt.output)
+			// Costs are sums of floats
+			if diff := got.CostUSD - tt.want.CostUSD; diff > 1e-9 || diff < -1e-9 {
+				t.Errorf("CostUSD = %v, want %v", got.CostUSD, tt.want.CostUSD)
+			}
+			got.CostUSD = tt.want.CostUSD
+			if got != tt.want {
+				t.Errorf("ParseUsage() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAiderBuildCommand(t *testing.T) {
+	e := &AiderExecutor{}
+	cmd := e.BuildCommand("-m foo", "20260101-120000-abc/../x", []string{"a.go"}, "gpt-5", true)
+	want := []string{"aider", "--message=-m foo", "--yes-always", "--no-pretty", "--no-check-update",
+		"--chat-history-file", ".aider.telecode-20260101-120000-abc_.._x.chat.history.md",
+		"--input-history-file", ".aider.telecode-20260101-120000-abc_.._x.input.history",
+		"--restore-chat-history", "--model", "gpt-5", "--chat-mode", "ask", "--no-auto-commits", "--file", "a.go"}
+	if !slices.Equal(cmd, want) {
+		t.Errorf("BuildCommand() = %q, want %q", cmd, want)
+	}
+	if id := e.NewSessionID(); strings.ContainsAny(id, "/ ") || id == e.NewSessionID() {
+		t.Errorf("NewSessionID() = %q, want a unique file name part", id)
+	}
+}
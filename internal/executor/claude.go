@@ -2,46 +2,54 @@ package executor
 
 import (
 	"os/exec"
-	"regexp"
 )
 
 // ClaudeExecutor implements Executor for Claude Code CLI
 type ClaudeExecutor struct{}
 
 // BuildCommand builds the Claude Code command
-func (e *ClaudeExecutor) BuildCommand(prompt, sessionID, imagePath string, model string) []string {
-	cmd := []string{"claude", "-p", prompt}
+func (e *ClaudeExecutor) BuildCommand(prompt, sessionID string, files []string, model string, readOnly bool) []string {
+	// stream-json requires --verbose in print mode
+	cmd := []string{"claude", "-p", "--output-format", "stream-json", "--verbose"}
+
+	if model != "" {
+		cmd = append(cmd, "--model", model)
+	}
+
+	if readOnly {
+		cmd = append(cmd, "--permission-mode", "plan")
+	}
 
 	if sessionID != "" {
 		cmd = append(cmd, "--resume", sessionID)
 	}
 
-	if imagePath != "" {
-		// Claude Code appends file path at the end of arguments
-		cmd = append(cmd, imagePath)
-	}
+	// The prompt and file paths come last, after "--" so that a prompt
+	// starting with "-" is not taken for a flag
+	cmd = append(cmd, "--", prompt)
+	cmd = append(cmd, files...)
 
 	return cmd
 }
 
-// ParseSessionID extracts session ID from Claude Code output
+// ParseSessionID extracts session ID from Claude Code stream-json output
 func (e *ClaudeExecutor) ParseSessionID(output string) string {
-	// Try various session ID formats
-	patterns := []string{
-		`session[:\s]+([a-zA-Z0-9_-]+)`,
-		`session\s*id[:\s]+([a-zA-Z0-9_-]+)`,
-		`resuming\s+session[:\s]+([a-zA-Z0-9_-]+)`,
-	}
+	return ParseClaudeStream(output).SessionID
+}
 
-	for _, pattern := range patterns {
-		re := regexp.MustCompile(pattern)
-		match := re.FindStringSubmatch(output)
-		if len(match) > 1 {
-			return match[1]
-		}
+// ParseUsage returns the usage of the final result record
+func (e *ClaudeExecutor) ParseUsage(output string) Usage {
+	result := ParseClaudeStream(output).Result
+	if result == nil {
+		return Usage{}
+	}
+	return Usage{
+		InputTokens:      result.Usage.InputTokens,
+		OutputTokens:     result.Usage.OutputTokens,
+		CacheReadTokens:  result.Usage.CacheReadInputTokens,
+		CacheWriteTokens: result.Usage.CacheCreationInputTokens,
+		CostUSD:          result.TotalCostUSD,
 	}
-
-	return ""
 }
 
 // Name returns the Executor name
@@ -0,0 +1,167 @@
+package executor
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// claudeEvent is a single line of `claude --output-format stream-json` output
+type claudeEvent struct {
+	Type      string         `json:"type"`
+	Subtype   string         `json:"subtype,omitempty"`
+	SessionID string         `json:"session_id,omitempty"`
+	Message   *claudeMessage `json:"message,omitempty"`
+
+	// Fields of the final "result" event
+	Result       string       `json:"result,omitempty"`
+	IsError      bool         `json:"is_error,omitempty"`
+	DurationMS   int64        `json:"duration_ms,omitempty"`
+	NumTurns     int          `json:"num_turns,omitempty"`
+	TotalCostUSD float64      `json:"total_cost_usd,omitempty"`
+	Usage        *ClaudeUsage `json:"usage,omitempty"`
+}
+
+// claudeMessage is an assistant or user message inside a stream event
+type claudeMessage struct {
+	Role    string          `json:"role"`
+	Content []claudeContent `json:"content"`
+}
+
+// claudeContent is a content block of a message
+type claudeContent struct {
+	Type  string          `json:"type"`
+	Text  string          `json:"text,omitempty"`
+	ID    string          `json:"id,omitempty"`
+	Name  string          `json:"name,omitempty"`
+	Input json.RawMessage `json:"input,omitempty"`
+}
+
+// ClaudeUsage is the token usage reported by Claude Code
+type ClaudeUsage struct {
+	InputTokens              int64 `json:"input_tokens"`
+	OutputTokens             int64 `json:"output_tokens"`
+	CacheCreationInputTokens int64 `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int64 `json:"cache_read_input_tokens"`
+}
+
+// ClaudeToolUse is a tool invocation made by the assistant
+type ClaudeToolUse struct {
+	ID    string
+	Name  string
+	Input map[string]interface{}
+}
+
+// ClaudeResult is the final record of a Claude Code run
+type ClaudeResult struct {
+	Text         string
+	IsError      bool
+	DurationMS   int64
+	NumTurns     int
+	TotalCostUSD float64
+	Usage        ClaudeUsage
+}
+
+// ClaudeStream is the parsed stream-json output of a Claude Code run
+type ClaudeStream struct {
+	SessionID string
+	Texts     []string
+	ToolUses  []ClaudeToolUse
+	Result    *ClaudeResult
+
+	// Steps holds assistant texts and tool-use summaries in the order they occurred
+	Steps []string
+}
+
+// ParseClaudeStream parses Claude Code stream-json output, skipping non-JSON lines
+func ParseClaudeStream(output string) *ClaudeStream {
+	stream := &ClaudeStream{}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] != '{' {
+			continue
+		}
+
+		var event claudeEvent
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			// Not a JSON line, skip
+			continue
+		}
+
+		if event.SessionID != "" {
+			stream.SessionID = event.SessionID
+		}
+
+		switch event.Type {
+		case "assistant":
+			if event.Message == nil {
+				continue
+			}
+			for _, content := range event.Message.Content {
+				switch content.Type {
+				case "text":
+					if content.Text != "" {
+						stream.Texts = append(stream.Texts, content.Text)
+						stream.Steps = append(stream.Steps, content.Text)
+					}
+				case "tool_use":
+					toolUse := ClaudeToolUse{ID: content.ID, Name: content.Name}
+					_ = json.Unmarshal(content.Input, &toolUse.Input)
+					stream.ToolUses = append(stream.ToolUses, toolUse)
+					stream.Steps = append(stream.Steps, "🔧 "+toolUse.Summary())
+				}
+			}
+		case "result":
+			result := &ClaudeResult{
+				Text:         event.Result,
+				IsError:      event.IsError,
+				DurationMS:   event.DurationMS,
+				NumTurns:     event.NumTurns,
+				TotalCostUSD: event.TotalCostUSD,
+			}
+			if event.Usage != nil {
+				result.Usage = *event.Usage
+			}
+			stream.Result = result
+		}
+	}
+
+	return stream
+}
+
+// Text returns the final answer: the result record if present, otherwise all assistant texts
+func (s *ClaudeStream) Text() string {
+	if s.Result != nil && s.Result.Text != "" {
+		return s.Result.Text
+	}
+	return strings.Join(s.Texts, "\n\n")
+}
+
+// Summary returns a one-line description of the tool call
+func (t ClaudeToolUse) Summary() string {
+	return toolSummary(t.Name, t.Input)
+}
+
+// toolSummary returns a one-line description of a tool call with its input
+func toolSummary(name string, input map[string]interface{}) string {
+	// Show the most descriptive input field the common tools have
+	for _, key := range []string{"command", "file_path", "absolute_path", "pattern", "url", "query", "description"} {
+		if value, ok := input[key].(string); ok && value != "" {
+			return fmt.Sprintf("%s: %s", name, shortLine(value))
+		}
+	}
+	return name
+}
+
+// shortLine collapses whitespace and truncates s to one short line
+func shortLine(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if runes := []rune(s); len(runes) > 80 {
+		s = string(runes[:80]) + "…"
+	}
+	return s
+}
@@ -0,0 +1,95 @@
+package executor
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// readSample returns a recorded CLI output from testdata
+func readSample(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestParseClaudeStream(t *testing.T) {
+	sample := readSample(t, "claude.jsonl")
+	lines := strings.Split(strings.TrimSpace(sample), "\n")
+
+	tests := []struct {
+		name      string
+		output    string
+		sessionID string
+		text      string
+		steps     []string
+		usage     Usage
+	}{
+		{
+			name:      "complete run",
+			output:    sample,
+			sessionID: "9f1c2d3e-4b5a-6789-abcd-ef0123456789",
+			text:      "All tests pass and main.go is fixed.",
+			steps: []string{
+				"Let me look at the tests.",
+				"🔧 Bash: go test ./...",
+				"🔧 Edit: /home/user/project/main.go",
+				"All tests pass and main.go is fixed.",
+			},
+			usage: Usage{InputTokens: 12, OutputTokens: 310, CacheReadTokens: 20480, CacheWriteTokens: 5120, CostUSD: 0.0421},
+		},
+		{
+			name:      "cancelled before the result",
+			output:    strings.Join(lines[:3], "\n"),
+			sessionID: "9f1c2d3e-4b5a-6789-abcd-ef0123456789",
+			text:      "Let me look at the tests.",
+			steps:     []string{"Let me look at the tests.", "🔧 Bash: go test ./..."},
+		},
+		{
+			name:      "non-JSON lines are skipped",
+			output:    "Warning: something\n" + lines[0] + "\n{not json\n" + lines[5],
+			sessionID: "9f1c2d3e-4b5a-6789-abcd-ef0123456789",
+			text:      "All tests pass and main.go is fixed.",
+			steps:     []string{"All tests pass and main.go is fixed."},
+		},
+		{
+			name:      "error result",
+			output:    `{"type":"result","subtype":"error_during_execution","is_error":true,"result":"Credit balance is too low","session_id":"abc","total_cost_usd":0}`,
+			sessionID: "abc",
+			text:      "Credit balance is too low",
+		},
+		{
+			name: "empty output",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := ParseClaudeStream(tt.output)
+			if stream.SessionID != tt.sessionID {
+				t.Errorf("SessionID = %q, want %q", stream.SessionID, tt.sessionID)
+			}
+			if got := stream.Text(); got != tt.text {
+				t.Errorf("Text() = %q, want %q", got, tt.text)
+			}
+			if !slices.Equal(stream.Steps, tt.steps) {
+				t.Errorf("Steps = %q, want %q", stream.Steps, tt.steps)
+			}
+			if got := (&ClaudeExecutor{}).ParseUsage(tt.output); got != tt.usage {
+				t.Errorf("ParseUsage() = %+v, want %+v", got, tt.usage)
+			}
+		})
+	}
+}
+
+func TestClaudeBuildCommand(t *testing.T) {
+	cmd := (&ClaudeExecutor{}).BuildCommand("--help", "sess", []string{"/tmp/a.png"}, "sonnet", true)
+	want := []string{"claude", "-p", "--output-format", "stream-json", "--verbose",
+		"--model", "sonnet", "--permission-mode", "plan", "--resume", "sess", "--", "--help", "/tmp/a.png"}
+	if !slices.Equal(cmd, want) {
+		t.Errorf("BuildCommand() = %q, want %q", cmd, want)
+	}
+}
@@ -0,0 +1,205 @@
+package executor
+
+import (
+	"bufio"
+	"encoding/json"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// imageExtensions lists the file types passed to CLIs as images rather than paths
+var imageExtensions = map[string]bool{".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".webp": true}
+
+// isImage reports whether a file is an image by its extension
+func isImage(path string) bool {
+	return imageExtensions[strings.ToLower(filepath.Ext(path))]
+}
+
+// CodexExecutor implements Executor for the OpenAI Codex CLI
+type CodexExecutor struct{}
+
+// BuildCommand builds the `codex exec` command
+func (e *CodexExecutor) BuildCommand(prompt, sessionID string, files []string, model string, readOnly bool) []string {
+	cmd := []string{"codex", "exec", "--json", "--skip-git-repo-check"}
+
+	// codex exec is read-only unless it is allowed to write the workspace
+	if readOnly {
+		cmd = append(cmd, "--sandbox", "read-only")
+	} else {
+		cmd = append(cmd, "--full-auto")
+	}
+
+	if model != "" {
+		cmd = append(cmd, "--model", model)
+	}
+
+	// Images are attached; other files are read by the agent from their paths.
+	// --image takes several values, so the "=" form keeps it off the prompt.
+	var paths []string
+	for _, file := range files {
+		if isImage(file) {
+			cmd = append(cmd, "--image="+file)
+		} else {
+			paths = append(paths, file)
+		}
+	}
+	if len(paths) > 0 {
+		prompt += "\n\nAttached files:\n- " + strings.Join(paths, "\n- ")
+	}
+
+	if sessionID != "" {
+		cmd = append(cmd, "resume", sessionID)
+	}
+
+	// A prompt starting with "-" must not be taken for a flag
+	return append(cmd, "--", prompt)
+}
+
+// ParseSessionID extracts the thread ID from `codex exec --json` output
+func (e *CodexExecutor) ParseSessionID(output string) string {
+	return ParseCodexStream(output).SessionID
+}
+
+// ParseUsage returns the token usage of all turns; Codex reports no cost
+func (e *CodexExecutor) ParseUsage(output string) Usage {
+	u := ParseCodexStream(output).Usage
+	// Cached tokens are part of the input tokens
+	return Usage{
+		InputTokens:     u.InputTokens - u.CachedInputTokens,
+		OutputTokens:    u.OutputTokens,
+		CacheReadTokens: u.CachedInputTokens,
+	}
+}
+
+// Name returns the Executor name
+func (e *CodexExecutor) Name() string {
+	return "codex"
+}
+
+// Stats returns statistics information
+func (e *CodexExecutor) Stats() (string, error) {
+	// Codex has no stats command, just check installation
+	_, err := exec.LookPath("codex")
+	if err != nil {
+		return "Codex CLI is not installed", nil
+	}
+	return "Codex CLI is installed", nil
+}
+
+// codexEvent is a single line of `codex exec --json` output
+type codexEvent struct {
+	Type     string      `json:"type"`
+	ThreadID string      `json:"thread_id,omitempty"`
+	Item     *codexItem  `json:"item,omitempty"`
+	Usage    *CodexUsage `json:"usage,omitempty"`
+	Message  string      `json:"message,omitempty"`
+	Error    *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// codexItem is a thread item such as an agent message or a command execution
+type codexItem struct {
+	Type    string `json:"type"`
+	Text    string `json:"text,omitempty"`
+	Command string `json:"command,omitempty"`
+	Query   string `json:"query,omitempty"`
+	Message string `json:"message,omitempty"`
+	Changes []struct {
+		Path string `json:"path"`
+		Kind string `json:"kind"`
+	} `json:"changes,omitempty"`
+}
+
+// CodexUsage is the token usage of a Codex turn
+type CodexUsage struct {
+	InputTokens       int64 `json:"input_tokens"`
+	CachedInputTokens int64 `json:"cached_input_tokens"`
+	OutputTokens      int64 `json:"output_tokens"`
+}
+
+// CodexStream is the parsed JSONL output of a `codex exec --json` run
+type CodexStream struct {
+	SessionID string
+	Texts     []string
+	Errors    []string
+	Usage     CodexUsage
+
+	// Steps holds agent messages, commands, file changes and errors in the order they occurred
+	Steps []string
+}
+
+// ParseCodexStream parses `codex exec --json` output, skipping non-JSON lines
+func ParseCodexStream(output string) *CodexStream {
+	stream := &CodexStream{}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] != '{' {
+			continue
+		}
+
+		var event codexEvent
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			// Not a JSON line, skip
+			continue
+		}
+
+		switch event.Type {
+		case "thread.started":
+			stream.SessionID = event.ThreadID
+		case "item.completed":
+			if event.Item == nil {
+				continue
+			}
+			switch item := event.Item; item.Type {
+			case "agent_message":
+				if item.Text != "" {
+					stream.Texts = append(stream.Texts, item.Text)
+					stream.Steps = append(stream.Steps, item.Text)
+				}
+			case "command_execution":
+				stream.Steps = append(stream.Steps, "🔧 "+shortLine(item.Command))
+			case "web_search":
+				stream.Steps = append(stream.Steps, "🔎 "+shortLine(item.Query))
+			case "file_change":
+				for _, change := range item.Changes {
+					stream.Steps = append(stream.Steps, "📝 "+change.Kind+" "+change.Path)
+				}
+			case "error":
+				stream.addError(item.Message)
+			}
+		case "turn.completed":
+			if event.Usage != nil {
+				stream.Usage.InputTokens += event.Usage.InputTokens
+				stream.Usage.CachedInputTokens += event.Usage.CachedInputTokens
+				stream.Usage.OutputTokens += event.Usage.OutputTokens
+			}
+		case "turn.failed":
+			if event.Error != nil {
+				stream.addError(event.Error.Message)
+			}
+		case "error":
+			stream.addError(event.Message)
+		}
+	}
+
+	return stream
+}
+
+// addError records an error reported by Codex
+func (s *CodexStream) addError(message string) {
+	s.Errors = append(s.Errors, message)
+	s.Steps = append(s.Steps, "❌ "+message)
+}
+
+// Text returns the agent messages, or the errors if there are none
+func (s *CodexStream) Text() string {
+	if len(s.Texts) == 0 && len(s.Errors) > 0 {
+		return "❌ " + strings.Join(s.Errors, "\n❌ ")
+	}
+	return strings.Join(s.Texts, "\n\n")
+}
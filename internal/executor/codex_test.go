@@ -0,0 +1,98 @@
+package executor
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseCodexStream(t *testing.T) {
+	tests := []struct {
+		name      string
+		output    string
+		sessionID string
+		text      string
+		steps     []string
+		usage     Usage
+	}{
+		{
+			name:      "complete run",
+			output:    readSample(t, "codex.jsonl"),
+			sessionID: "0199a213-81c0-7800-8aa1-bbab2a035a53",
+			text:      "Fixed the loop and added a test.",
+			steps: []string{
+				"🔧 bash -lc 'go test ./...'",
+				"📝 update /repo/main.go",
+				"📝 add /repo/main_test.go",
+				"Fixed the loop and added a test.",
+			},
+			usage: Usage{InputTokens: 315, OutputTokens: 122, CacheReadTokens: 24448},
+		},
+		{
+			name: "failed turn",
+			output: `{"type":"thread.started","thread_id":"t1"}
+{"type":"turn.started"}
+{"type":"error","message":"stream disconnected before completion"}
+{"type":"turn.failed","error":{"message":"unexpected status 401 Unauthorized"}}`,
+			sessionID: "t1",
+			text:      "❌ stream disconnected before completion\n❌ unexpected status 401 Unauthorized",
+			steps:     []string{"❌ stream disconnected before completion", "❌ unexpected status 401 Unauthorized"},
+		},
+		{
+			name: "usage of several turns and noise lines",
+			output: `Reading prompt from stdin...
+{"type":"turn.completed","usage":{"input_tokens":100,"cached_input_tokens":40,"output_tokens":10}}
+{"type":"turn.completed","usage":{"input_tokens":200,"cached_input_tokens":100,"output_tokens":20}}`,
+			usage: Usage{InputTokens: 160, OutputTokens: 30, CacheReadTokens: 140},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := ParseCodexStream(tt.output)
+			if stream.SessionID != tt.sessionID {
+				t.Errorf("SessionID = %q, want %q", stream.SessionID, tt.sessionID)
+			}
+			if got := stream.Text(); got != tt.text {
+				t.Errorf("Text() = %q, want %q", got, tt.text)
+			}
+			if !slices.Equal(stream.Steps, tt.steps) {
+				t.Errorf("Steps = %q, want %q", stream.Steps, tt.steps)
+			}
+			if got := (&CodexExecutor{}).ParseUsage(tt.output); got != tt.usage {
+				t.Errorf("ParseUsage() = %+v, want %+v", got, tt.usage)
+			}
+		})
+	}
+}
+
+func TestCodexBuildCommand(t *testing.T) {
+	tests := []struct {
+		name      string
+		prompt    string
+		sessionID string
+		files     []string
+		readOnly  bool
+		want      []string
+	}{
+		{
+			name:   "new session",
+			prompt: "--help",
+			want:   []string{"codex", "exec", "--json", "--skip-git-repo-check", "--full-auto", "--", "--help"},
+		},
+		{
+			name:      "resumed read-only session with files",
+			prompt:    "look",
+			sessionID: "t1",
+			files:     []string{"/tmp/shot.PNG", "/tmp/notes.txt"},
+			readOnly:  true,
+			want: []string{"codex", "exec", "--json", "--skip-git-repo-check", "--sandbox", "read-only",
+				"--image=/tmp/shot.PNG", "resume", "t1", "--", "look\n\nAttached files:\n- /tmp/notes.txt"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (&CodexExecutor{}).BuildCommand(tt.prompt, tt.sessionID, tt.files, "", tt.readOnly); !slices.Equal(got, tt.want) {
+				t.Errorf("BuildCommand() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package executor
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"telecode/internal/config"
+)
+
+// CustomExecutor implements Executor for a CLI declared in the config
+type CustomExecutor struct {
+	cfg       config.CustomCLIConfig
+	sessionRe *regexp.Regexp
+	outputRe  *regexp.Regexp
+}
+
+// NewCustomExecutor creates an executor for a custom CLI
+func NewCustomExecutor(cfg config.CustomCLIConfig) (*CustomExecutor, error) {
+	e := &CustomExecutor{cfg: cfg}
+	var err error
+	if cfg.SessionID.Regex != "" {
+		if e.sessionRe, err = regexp.Compile(cfg.SessionID.Regex); err != nil {
+			return nil, fmt.Errorf("custom CLI %s: invalid session_id regex: %w", cfg.Name, err)
+		}
+	}
+	if cfg.Output.Regex != "" {
+		if e.outputRe, err = regexp.Compile(cfg.Output.Regex); err != nil {
+			return nil, fmt.Errorf("custom CLI %s: invalid output regex: %w", cfg.Name, err)
+		}
+	}
+	return e, nil
+}
+
+// BuildCommand builds the command from the configured templates. It returns
+// nil for read-only runs if the CLI has no read-only arguments.
+func (e *CustomExecutor) BuildCommand(prompt, sessionID string, files []string, model string, readOnly bool) []string {
+	if readOnly && len(e.cfg.ReadOnlyArgs) == 0 {
+		return nil
+	}
+
+	vars := strings.NewReplacer("{prompt}", prompt, "{session}", sessionID, "{model}", model)
+	cmd := expandArgs(nil, e.cfg.Command, vars)
+
+	if sessionID != "" {
+		cmd = expandArgs(cmd, e.cfg.SessionArgs, vars)
+	}
+	if model != "" {
+		cmd = expandArgs(cmd, e.cfg.ModelArgs, vars)
+	}
+	if readOnly {
+		cmd = expandArgs(cmd, e.cfg.ReadOnlyArgs, vars)
+	}
+	for _, file := range files {
+		cmd = expandArgs(cmd, e.cfg.FileArgs, strings.NewReplacer("{file}", file))
+	}
+
+	return cmd
+}
+
+// expandArgs appends the template arguments with their placeholders replaced
+func expandArgs(cmd, template []string, vars *strings.Replacer) []string {
+	for _, arg := range template {
+		cmd = append(cmd, vars.Replace(arg))
+	}
+	return cmd
+}
+
+// ParseSessionID extracts the session ID with the configured rule
+func (e *CustomExecutor) ParseSessionID(output string) string {
+	if values := e.extract(output, e.sessionRe, e.cfg.SessionID.JSONPath); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// ExtractOutput returns the response text found with the configured output rule,
+// or the whole output if there is no rule
+func (e *CustomExecutor) ExtractOutput(output string) string {
+	if e.outputRe == nil && e.cfg.Output.JSONPath == "" {
+		return output
+	}
+	return strings.Join(e.extract(output, e.outputRe, e.cfg.Output.JSONPath), "\n\n")
+}
+
+// extract returns the non-empty values found by a regex or a JSON path
+func (e *CustomExecutor) extract(output string, re *regexp.Regexp, jsonPath string) []string {
+	var values []string
+	if re != nil {
+		for _, match := range re.FindAllStringSubmatch(output, -1) {
+			value := match[0]
+			if len(match) > 1 {
+				value = match[1]
+			}
+			if value != "" {
+				values = append(values, value)
+			}
+		}
+		return values
+	}
+	if jsonPath != "" {
+		return jsonPathValues(output, jsonPath)
+	}
+	return nil
+}
+
+// Name returns the Executor name
+func (e *CustomExecutor) Name() string {
+	return e.cfg.Name
+}
+
+// Stats runs the configured stats command
+func (e *CustomExecutor) Stats() (string, error) {
+	if len(e.cfg.Stats) == 0 {
+		return fmt.Sprintf("No statistics configured for %s", e.cfg.Name), nil
+	}
+	output, err := exec.Command(e.cfg.Stats[0], e.cfg.Stats[1:]...).CombinedOutput()
+	if err != nil {
+		return "Could not retrieve statistics", nil
+	}
+	return string(output), nil
+}
+
+// jsonPathValues returns the values at a dotted path ("result.text", "items.0.id")
+// in output parsed as one JSON document, or else in each JSON line of it
+func jsonPathValues(output, path string) []string {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	segments := strings.Split(path, ".")
+
+	if doc, ok := decodeJSON(output); ok {
+		if value, ok := lookupJSON(doc, segments); ok {
+			return []string{value}
+		}
+		return nil
+	}
+
+	var values []string
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		doc, ok := decodeJSON(scanner.Text())
+		if !ok {
+			continue
+		}
+		if value, ok := lookupJSON(doc, segments); ok {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// decodeJSON parses s as a single JSON value, keeping numbers as written
+func decodeJSON(s string) (any, bool) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil || dec.More() {
+		return nil, false
+	}
+	return doc, true
+}
+
+// lookupJSON follows path segments through objects and arrays and formats
+// the value found; empty strings and nulls count as not found
+func lookupJSON(doc any, segments []string) (string, bool) {
+	for _, segment := range segments {
+		switch node := doc.(type) {
+		case map[string]any:
+			doc = node[segment]
+		case []any:
+			i, err := strconv.Atoi(segment)
+			if err != nil || i < 0 || i >= len(node) {
+				return "", false
+			}
+			doc = node[i]
+		default:
+			return "", false
+		}
+	}
+
+	switch value := doc.(type) {
+	case nil:
+		return "", false
+	case string:
+		return value, value != ""
+	case json.Number, bool:
+		return fmt.Sprint(value), true
+	default:
+		data, err := json.Marshal(value)
+		return string(data), err == nil
+	}
+}
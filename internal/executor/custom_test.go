@@ -0,0 +1,145 @@
+package executor
+
+import (
+	"slices"
+	"testing"
+
+	"telecode/internal/config"
+)
+
+func TestCustomBuildCommand(t *testing.T) {
+	cfg := config.CustomCLIConfig{
+		Name:         "mycli",
+		Command:      []string{"mycli", "run", "--json", "{prompt}"},
+		SessionArgs:  []string{"--resume", "{session}"},
+		FileArgs:     []string{"--file", "{file}"},
+		ModelArgs:    []string{"--model={model}"},
+		ReadOnlyArgs: []string{"--read-only"},
+	}
+	tests := []struct {
+		name      string
+		cfg       config.CustomCLIConfig
+		prompt    string
+		sessionID string
+		files     []string
+		model     string
+		readOnly  bool
+		want      []string
+	}{
+		{
+			name:   "prompt only",
+			cfg:    cfg,
+			prompt: "fix the bug",
+			want:   []string{"mycli", "run", "--json", "fix the bug"},
+		},
+		{
+			name:      "all arguments",
+			cfg:       cfg,
+			prompt:    "fix it",
+			sessionID: "s-42",
+			files:     []string{"/tmp/a.txt", "/tmp/b.png"},
+			model:     "fast",
+			readOnly:  true,
+			want: []string{"mycli", "run", "--json", "fix it", "--resume", "s-42", "--model=fast", "--read-only",
+				"--file", "/tmp/a.txt", "--file", "/tmp/b.png"},
+		},
+		{
+			name:   "placeholders in the prompt are kept",
+			cfg:    cfg,
+			prompt: "print {session} and {model}",
+			want:   []string{"mycli", "run", "--json", "print {session} and {model}"},
+		},
+		{
+			name:     "read-only without read-only arguments",
+			cfg:      config.CustomCLIConfig{Name: "mycli", Command: []string{"mycli", "{prompt}"}},
+			prompt:   "hi",
+			readOnly: true,
+			want:     nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := NewCustomExecutor(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := e.BuildCommand(tt.prompt, tt.sessionID, tt.files, tt.model, tt.readOnly); !slices.Equal(got, tt.want) {
+				t.Errorf("BuildCommand() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCustomExtract(t *testing.T) {
+	jsonLines := `starting...
+{"type":"start","session_id":"abc-123"}
+{"type":"message","result":"First part"}
+{"type":"message","result":""}
+{"type":"message","result":"Second part"}`
+
+	tests := []struct {
+		name      string
+		sessionID config.ExtractRule
+		output    config.ExtractRule
+		text      string
+		wantID    string
+		wantText  string
+	}{
+		{
+			name:      "JSON lines",
+			sessionID: config.ExtractRule{JSONPath: "session_id"},
+			output:    config.ExtractRule{JSONPath: "$.result"},
+			text:      jsonLines,
+			wantID:    "abc-123",
+			wantText:  "First part\n\nSecond part",
+		},
+		{
+			name:      "single JSON document with arrays and numbers",
+			sessionID: config.ExtractRule{JSONPath: "meta.id"},
+			output:    config.ExtractRule{JSONPath: "items.1.text"},
+			text:      "{\n  \"meta\": {\"id\": 12345678901234567890},\n  \"items\": [{\"text\": \"a\"}, {\"text\": \"b\"}]\n}",
+			wantID:    "12345678901234567890",
+			wantText:  "b",
+		},
+		{
+			name:     "missing path",
+			output:   config.ExtractRule{JSONPath: "items.5.text"},
+			text:     `{"items": []}`,
+			wantText: "",
+		},
+		{
+			name:      "regex with and without group",
+			sessionID: config.ExtractRule{Regex: `session: (\S+)`},
+			output:    config.ExtractRule{Regex: `(?m)^> .*$`},
+			text:      "session: xyz\n> line one\nnoise\n> line two",
+			wantID:    "xyz",
+			wantText:  "> line one\n\n> line two",
+		},
+		{
+			name:     "no output rule shows everything",
+			text:     "plain output\n",
+			wantText: "plain output\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := NewCustomExecutor(config.CustomCLIConfig{Name: "mycli", SessionID: tt.sessionID, Output: tt.output})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := e.ParseSessionID(tt.text); got != tt.wantID {
+				t.Errorf("ParseSessionID() = %q, want %q", got, tt.wantID)
+			}
+			if got := e.ExtractOutput(tt.text); got != tt.wantText {
+				t.Errorf("ExtractOutput() = %q, want %q", got, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestNewCustomExecutorInvalidRegex(t *testing.T) {
+	_, err := NewCustomExecutor(config.CustomCLIConfig{Name: "mycli", Output: config.ExtractRule{Regex: "("}})
+	if err == nil {
+		t.Error("NewCustomExecutor accepted an invalid regex")
+	}
+}
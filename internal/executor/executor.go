@@ -2,8 +2,9 @@ package executor
 
 // Executor defines the interface for CLI executors
 type Executor interface {
-	// BuildCommand builds the CLI command
-	BuildCommand(prompt string, sessionID string, imagePath string, model string) []string
+	// BuildCommand builds the CLI command; files are attached images or documents.
+	// readOnly runs the agent in a mode that only plans and answers, without edits.
+	BuildCommand(prompt string, sessionID string, files []string, model string, readOnly bool) []string
 
 	// ParseSessionID extracts session ID from output
 	ParseSessionID(output string) string
@@ -14,3 +15,31 @@ type Executor interface {
 	// Stats returns statistics information
 	Stats() (string, error)
 }
+
+// OutputExtractor is implemented by executors that find the response text
+// in their output themselves
+type OutputExtractor interface {
+	// ExtractOutput returns the response text found in output, "" if there is none yet
+	ExtractOutput(output string) string
+}
+
+// SessionAssigner is implemented by executors whose CLI does not create
+// session IDs; telecode assigns one before the first run of a session
+type SessionAssigner interface {
+	NewSessionID() string
+}
+
+// Usage is the token usage and cost of one run as reported by the CLI
+type Usage struct {
+	InputTokens      int64
+	OutputTokens     int64
+	CacheReadTokens  int64
+	CacheWriteTokens int64
+	CostUSD          float64
+}
+
+// UsageReporter is implemented by executors whose output reports token usage
+type UsageReporter interface {
+	// ParseUsage returns the usage found in output, zero if none was reported
+	ParseUsage(output string) Usage
+}
@@ -0,0 +1,191 @@
+package executor
+
+import (
+	"bufio"
+	"encoding/json"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// GeminiExecutor implements Executor for the Gemini CLI
+type GeminiExecutor struct{}
+
+// BuildCommand builds the Gemini CLI command
+func (e *GeminiExecutor) BuildCommand(prompt, sessionID string, files []string, model string, readOnly bool) []string {
+	// Files are attached with @path references, which must be inside the
+	// workspace or an included directory
+	dirs := make(map[string]bool)
+	var includes []string
+	for _, file := range files {
+		prompt += " @" + file
+		if dir := filepath.Dir(file); !dirs[dir] {
+			dirs[dir] = true
+			includes = append(includes, "--include-directories", dir)
+		}
+	}
+
+	// The "=" form keeps a prompt starting with "-" from being taken for a flag
+	cmd := []string{"gemini", "--prompt=" + prompt, "--output-format", "stream-json"}
+
+	// Without yolo, tools that edit files or run commands are not available headless
+	if readOnly {
+		cmd = append(cmd, "--approval-mode", "default")
+	} else {
+		cmd = append(cmd, "--approval-mode", "yolo")
+	}
+
+	if model != "" {
+		cmd = append(cmd, "--model", model)
+	}
+
+	if sessionID != "" {
+		cmd = append(cmd, "--resume", sessionID)
+	}
+
+	return append(cmd, includes...)
+}
+
+// ParseSessionID extracts the session ID from Gemini CLI stream-json output
+func (e *GeminiExecutor) ParseSessionID(output string) string {
+	return ParseGeminiStream(output).SessionID
+}
+
+// ParseUsage returns the token usage of the run; Gemini CLI reports no cost
+func (e *GeminiExecutor) ParseUsage(output string) Usage {
+	stats := ParseGeminiStream(output).Stats
+	return Usage{InputTokens: stats.InputTokens, OutputTokens: stats.OutputTokens}
+}
+
+// Name returns the Executor name
+func (e *GeminiExecutor) Name() string {
+	return "gemini"
+}
+
+// Stats returns statistics information
+func (e *GeminiExecutor) Stats() (string, error) {
+	// Gemini CLI has no stats command, just check installation
+	_, err := exec.LookPath("gemini")
+	if err != nil {
+		return "Gemini CLI is not installed", nil
+	}
+	return "Gemini CLI is installed", nil
+}
+
+// geminiEvent is a single line of `gemini --output-format stream-json` output
+type geminiEvent struct {
+	Type       string                 `json:"type"`
+	SessionID  string                 `json:"session_id,omitempty"`
+	Role       string                 `json:"role,omitempty"`
+	Content    string                 `json:"content,omitempty"`
+	Delta      bool                   `json:"delta,omitempty"`
+	ToolName   string                 `json:"tool_name,omitempty"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	Severity   string                 `json:"severity,omitempty"`
+	Message    string                 `json:"message,omitempty"`
+	Status     string                 `json:"status,omitempty"`
+	Error      *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+	Stats *GeminiStats `json:"stats,omitempty"`
+}
+
+// GeminiStats is the token usage reported at the end of a Gemini CLI run
+type GeminiStats struct {
+	TotalTokens  int64 `json:"total_tokens"`
+	InputTokens  int64 `json:"input_tokens"`
+	OutputTokens int64 `json:"output_tokens"`
+	DurationMS   int64 `json:"duration_ms"`
+	ToolCalls    int   `json:"tool_calls"`
+}
+
+// GeminiStream is the parsed stream-json output of a Gemini CLI run
+type GeminiStream struct {
+	SessionID string
+	Texts     []string
+	Errors    []string
+	Stats     GeminiStats
+
+	// Steps holds assistant texts, tool calls and errors in the order they occurred
+	Steps []string
+}
+
+// ParseGeminiStream parses Gemini CLI stream-json output, skipping non-JSON lines
+func ParseGeminiStream(output string) *GeminiStream {
+	stream := &GeminiStream{}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	// Assistant messages arrive in deltas until the next tool call
+	var text strings.Builder
+	flush := func() {
+		if t := strings.TrimSpace(text.String()); t != "" {
+			stream.Texts = append(stream.Texts, t)
+			stream.Steps = append(stream.Steps, t)
+		}
+		text.Reset()
+	}
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] != '{' {
+			continue
+		}
+
+		var event geminiEvent
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			// Not a JSON line, skip
+			continue
+		}
+
+		switch event.Type {
+		case "init":
+			stream.SessionID = event.SessionID
+		case "message":
+			if event.Role != "assistant" {
+				continue
+			}
+			// A message that is not a delta is complete on its own
+			if !event.Delta {
+				flush()
+			}
+			text.WriteString(event.Content)
+			if !event.Delta {
+				flush()
+			}
+		case "tool_use":
+			flush()
+			stream.Steps = append(stream.Steps, "🔧 "+toolSummary(event.ToolName, event.Parameters))
+		case "error":
+			if event.Severity != "warning" {
+				flush()
+				stream.addError(event.Message)
+			}
+		case "result":
+			flush()
+			if event.Error != nil {
+				stream.addError(event.Error.Message)
+			}
+			if event.Stats != nil {
+				stream.Stats = *event.Stats
+			}
+		}
+	}
+	flush()
+
+	return stream
+}
+
+// addError records an error reported by the Gemini CLI
+func (s *GeminiStream) addError(message string) {
+	s.Errors = append(s.Errors, message)
+	s.Steps = append(s.Steps, "❌ "+message)
+}
+
+// Text returns the assistant messages, or the errors if there are none
+func (s *GeminiStream) Text() string {
+	if len(s.Texts) == 0 && len(s.Errors) > 0 {
+		return "❌ " + strings.Join(s.Errors, "\n❌ ")
+	}
+	return strings.Join(s.Texts, "\n\n")
+}
@@ -0,0 +1,70 @@
+package executor
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseGeminiStream(t *testing.T) {
+	tests := []struct {
+		name      string
+		output    string
+		sessionID string
+		text      string
+		steps     []string
+		usage     Usage
+	}{
+		{
+			name:      "complete run",
+			output:    readSample(t, "gemini.jsonl"),
+			sessionID: "c25acda3-b110-4f8e-a8c3-2f1d7b9b0a11",
+			text:      "Let me read main.go.\n\nThe loop is fixed.",
+			steps:     []string{"Let me read main.go.", "🔧 read_file: /repo/main.go", "The loop is fixed."},
+			usage:     Usage{InputTokens: 10200, OutputTokens: 230},
+		},
+		{
+			name: "failed run",
+			output: `{"type":"init","session_id":"s1","model":"gemini-2.5-pro"}
+{"type":"result","status":"error","error":{"type":"FatalAuthenticationError","message":"Please set an Auth method"}}`,
+			sessionID: "s1",
+			text:      "❌ Please set an Auth method",
+			steps:     []string{"❌ Please set an Auth method"},
+		},
+		{
+			name: "whole messages and cut-off output",
+			output: `Loaded cached credentials.
+{"type":"init","session_id":"s2"}
+{"type":"message","role":"assistant","content":"First."}
+{"type":"message","role":"assistant","content":"Second, still stre","delta":true}`,
+			sessionID: "s2",
+			text:      "First.\n\nSecond, still stre",
+			steps:     []string{"First.", "Second, still stre"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := ParseGeminiStream(tt.output)
+			if stream.SessionID != tt.sessionID {
+				t.Errorf("SessionID = %q, want %q", stream.SessionID, tt.sessionID)
+			}
+			if got := stream.Text(); got != tt.text {
+				t.Errorf("Text() = %q, want %q", got, tt.text)
+			}
+			if !slices.Equal(stream.Steps, tt.steps) {
+				t.Errorf("Steps = %q, want %q", stream.Steps, tt.steps)
+			}
+			if got := (&GeminiExecutor{}).ParseUsage(tt.output); got != tt.usage {
+				t.Errorf("ParseUsage() = %+v, want %+v", got, tt.usage)
+			}
+		})
+	}
+}
+
+func TestGeminiBuildCommand(t *testing.T) {
+	cmd := (&GeminiExecutor{}).BuildCommand("-m foo", "s1", []string{"/tmp/in/a.png", "/tmp/in/b.txt"}, "gemini-2.5-flash", false)
+	want := []string{"gemini", "--prompt=-m foo @/tmp/in/a.png @/tmp/in/b.txt", "--output-format", "stream-json",
+		"--approval-mode", "yolo", "--model", "gemini-2.5-flash", "--resume", "s1", "--include-directories", "/tmp/in"}
+	if !slices.Equal(cmd, want) {
+		t.Errorf("BuildCommand() = %q, want %q", cmd, want)
+	}
+}
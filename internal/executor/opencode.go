@@ -1,30 +1,39 @@
 package executor
 
 import (
+	"bufio"
+	"encoding/json"
 	"os/exec"
 	"regexp"
+	"strings"
 )
 
 // OpenCodeExecutor implements Executor for OpenCode CLI
 type OpenCodeExecutor struct{}
 
 // BuildCommand builds the OpenCode command
-func (e *OpenCodeExecutor) BuildCommand(prompt, sessionID, imagePath string, model string) []string {
+func (e *OpenCodeExecutor) BuildCommand(prompt, sessionID string, files []string, model string, readOnly bool) []string {
 	// Use default model if not specified
 	if model == "" {
 		model = "anthropic/opus-4.6"
 	}
-	cmd := []string{"opencode", "run", "--format", "json", "--model", model, prompt}
+	cmd := []string{"opencode", "run", "--format", "json", "--model", model}
 
 	if sessionID != "" {
 		cmd = append(cmd, "--session", sessionID)
 	}
 
-	if imagePath != "" {
-		cmd = append(cmd, "--file", imagePath)
+	// The built-in plan agent cannot edit files or run commands
+	if readOnly {
+		cmd = append(cmd, "--agent", "plan")
 	}
 
-	return cmd
+	for _, file := range files {
+		cmd = append(cmd, "--file", file)
+	}
+
+	// A prompt starting with "-" must not be taken for a flag
+	return append(cmd, "--", prompt)
 }
 
 // ParseSessionID extracts session ID from OpenCode JSON output
@@ -39,6 +48,48 @@ func (e *OpenCodeExecutor) ParseSessionID(output string) string {
 	return ""
 }
 
+// openCodeStepFinish is the part of a step_finish event carrying the usage of a step
+type openCodeStepFinish struct {
+	Type string `json:"type"`
+	Part struct {
+		Cost   float64 `json:"cost"`
+		Tokens struct {
+			Input     int64 `json:"input"`
+			Output    int64 `json:"output"`
+			Reasoning int64 `json:"reasoning"`
+			Cache     struct {
+				Read  int64 `json:"read"`
+				Write int64 `json:"write"`
+			} `json:"cache"`
+		} `json:"tokens"`
+	} `json:"part"`
+}
+
+// ParseUsage sums the usage of all steps in OpenCode JSON output
+func (e *OpenCodeExecutor) ParseUsage(output string) Usage {
+	var usage Usage
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.Contains(line, `"step_finish"`) {
+			continue
+		}
+		var event openCodeStepFinish
+		if err := json.Unmarshal([]byte(line), &event); err != nil || event.Type != "step_finish" {
+			continue
+		}
+		tokens := event.Part.Tokens
+		usage.InputTokens += tokens.Input
+		usage.OutputTokens += tokens.Output + tokens.Reasoning
+		usage.CacheReadTokens += tokens.Cache.Read
+		usage.CacheWriteTokens += tokens.Cache.Write
+		usage.CostUSD += event.Part.Cost
+	}
+	return usage
+}
+
 // Name returns the Executor name
 func (e *OpenCodeExecutor) Name() string {
 	return "opencode"
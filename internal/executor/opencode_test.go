@@ -0,0 +1,46 @@
+package executor
+
+import (
+	"testing"
+)
+
+func TestOpenCodeParseUsage(t *testing.T) {
+	tests := []struct {
+		name      string
+		output    string
+		sessionID string
+		usage     Usage
+	}{
+		{
+			name:      "two steps",
+			output:    readSample(t, "opencode.jsonl"),
+			sessionID: "ses_6a1f3b2c9ffeX2kq7Ld0mZ",
+			usage:     Usage{InputTokens: 1350, OutputTokens: 112, CacheReadTokens: 19200, CacheWriteTokens: 300, CostUSD: 0.0156},
+		},
+		{
+			name:   "text mentioning step_finish is not a step",
+			output: `{"type":"text","part":{"text":"the \"step_finish\" event"}}`,
+		},
+		{
+			name:   "no JSON",
+			output: "Error: model not found",
+		},
+	}
+	e := &OpenCodeExecutor{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := e.ParseSessionID(tt.output); got != tt.sessionID {
+				t.Errorf("ParseSessionID() = %q, want %q", got, tt.sessionID)
+			}
+			got := e.ParseUsage(tt.output)
+			// Costs are sums of floats
+			if diff := got.CostUSD - tt.usage.CostUSD; diff > 1e-9 || diff < -1e-9 {
+				t.Errorf("CostUSD = %v, want %v", got.CostUSD, tt.usage.CostUSD)
+			}
+			got.CostUSD = tt.usage.CostUSD
+			if got != tt.usage {
+				t.Errorf("ParseUsage() = %+v, want %+v", got, tt.usage)
+			}
+		})
+	}
+}
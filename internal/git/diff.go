@@ -0,0 +1,101 @@
+package git
+
+import (
+	"context"
+	"strconv"
+	"strings"
+)
+
+// FileStat is the number of lines added and removed in a file
+type FileStat struct {
+	Path    string
+	Added   int
+	Removed int
+	Binary  bool
+}
+
+// Diff is a unified diff with its per-file summary
+type Diff struct {
+	Patch string
+	Files []FileStat
+}
+
+// Totals returns the lines added and removed over all files
+func (d Diff) Totals() (added, removed int) {
+	for _, f := range d.Files {
+		added += f.Added
+		removed += f.Removed
+	}
+	return added, removed
+}
+
+// DiffHead returns the changes of the working tree against HEAD, limited
+// to path if it is not empty. Untracked files are not included.
+func DiffHead(ctx context.Context, dir, path string) (Diff, error) {
+	args := []string{"HEAD", "--"}
+	if path != "" {
+		args = append(args, path)
+	}
+	return diff(ctx, dir, "diff", args...)
+}
+
+// DiffStaged returns the changes staged for the next commit
+func DiffStaged(ctx context.Context, dir string) (Diff, error) {
+	return diff(ctx, dir, "diff", "--cached", "--")
+}
+
+// StagedChanges returns the files staged for the next commit with their status
+// ('A' added, 'M' modified, 'D' deleted, ...)
+func StagedChanges(ctx context.Context, dir string) ([]Change, error) {
+	out, err := Run(ctx, dir, "diff", "--cached", "--no-renames", "--name-status")
+	if err != nil {
+		return nil, err
+	}
+	var changes []Change
+	for _, line := range strings.Split(out, "\n") {
+		if status, path, ok := strings.Cut(line, "\t"); ok && status != "" {
+			changes = append(changes, Change{Status: status[0], Path: path})
+		}
+	}
+	return changes, nil
+}
+
+// DiffCommit returns the changes introduced by a commit
+func DiffCommit(ctx context.Context, dir, rev string) (Diff, error) {
+	return diff(ctx, dir, "show", "--format=", rev, "--")
+}
+
+// diff runs a git command producing a diff, once for the patch and once
+// for the per-file summary
+func diff(ctx context.Context, dir, command string, args ...string) (Diff, error) {
+	patch, err := Run(ctx, dir, append([]string{command, "--no-color", "--no-ext-diff"}, args...)...)
+	if err != nil {
+		return Diff{}, err
+	}
+	numstat, err := Run(ctx, dir, append([]string{command, "--numstat"}, args...)...)
+	if err != nil {
+		return Diff{}, err
+	}
+	return Diff{Patch: patch, Files: parseNumstat(numstat)}, nil
+}
+
+// parseNumstat parses `git diff --numstat` output ("added<TAB>removed<TAB>path",
+// with "-" counts for binary files)
+func parseNumstat(out string) []FileStat {
+	var files []FileStat
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		f := FileStat{Path: fields[2]}
+		if fields[0] == "-" && fields[1] == "-" {
+			f.Binary = true
+		} else {
+			f.Added, _ = strconv.Atoi(fields[0])
+			f.Removed, _ = strconv.Atoi(fields[1])
+		}
+		files = append(files, f)
+	}
+	return files
+}
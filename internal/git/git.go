@@ -0,0 +1,199 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Run runs git in dir and returns its standard output with the trailing
+// newline removed. Errors carry git's own message from standard error.
+func Run(ctx context.Context, dir string, args ...string) (string, error) {
+	return runEnv(ctx, dir, nil, args...)
+}
+
+// runEnv runs git like Run with extra environment variables
+func runEnv(ctx context.Context, dir string, env []string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	if env != nil {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return strings.TrimRight(stdout.String(), "\n"), nil
+}
+
+// IsRepo reports whether dir is inside a git work tree
+func IsRepo(ctx context.Context, dir string) bool {
+	out, err := Run(ctx, dir, "rev-parse", "--is-inside-work-tree")
+	return err == nil && out == "true"
+}
+
+// CurrentBranch returns the branch checked out in dir
+func CurrentBranch(ctx context.Context, dir string) (string, error) {
+	branch, err := Run(ctx, dir, "symbolic-ref", "--quiet", "--short", "HEAD")
+	if err != nil {
+		return "", errors.New("HEAD is detached, check out a branch first")
+	}
+	return branch, nil
+}
+
+// BranchExists reports whether a local branch exists
+func BranchExists(ctx context.Context, dir, branch string) bool {
+	_, err := Run(ctx, dir, "rev-parse", "--verify", "--quiet", "refs/heads/"+branch)
+	return err == nil
+}
+
+// DeleteBranch deletes a local branch, merged or not
+func DeleteBranch(ctx context.Context, dir, branch string) error {
+	_, err := Run(ctx, dir, "branch", "-D", branch)
+	return err
+}
+
+// Changes returns the paths with uncommitted changes, including untracked files
+func Changes(ctx context.Context, dir string) ([]string, error) {
+	out, err := Run(ctx, dir, "status", "--porcelain", "--untracked-files=all")
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, line := range strings.Split(out, "\n") {
+		if len(line) > 3 {
+			paths = append(paths, line[3:])
+		}
+	}
+	return paths, nil
+}
+
+// Identity is the author and committer of commits; an empty identity uses
+// the user configured in git
+type Identity struct {
+	Name  string
+	Email string
+}
+
+// env returns the environment variables setting the identity, or nil if it is empty
+func (id Identity) env() []string {
+	if id.Name == "" {
+		return nil
+	}
+	return []string{
+		"GIT_AUTHOR_NAME=" + id.Name, "GIT_AUTHOR_EMAIL=" + id.Email,
+		"GIT_COMMITTER_NAME=" + id.Name, "GIT_COMMITTER_EMAIL=" + id.Email,
+	}
+}
+
+// CommitAll stages every change in dir and commits it. It reports whether
+// there was anything to commit.
+func CommitAll(ctx context.Context, dir, message string, author Identity) (bool, error) {
+	if err := StageAll(ctx, dir); err != nil {
+		return false, err
+	}
+	if !HasStaged(ctx, dir) {
+		return false, nil
+	}
+	if _, err := CommitStaged(ctx, dir, message, author); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// StageAll stages every change in dir, including new and deleted files
+func StageAll(ctx context.Context, dir string) error {
+	_, err := Run(ctx, dir, "add", "--all")
+	return err
+}
+
+// HasStaged reports whether the index of dir has changes to commit
+func HasStaged(ctx context.Context, dir string) bool {
+	// diff --quiet exits with 1 when something is staged
+	_, err := Run(ctx, dir, "diff", "--cached", "--quiet")
+	return err != nil
+}
+
+// StagedTree writes the index of dir as a tree and returns its hash, which
+// identifies exactly what a commit would contain
+func StagedTree(ctx context.Context, dir string) (string, error) {
+	return Run(ctx, dir, "write-tree")
+}
+
+// Unstage resets the index of dir to HEAD, keeping the files
+func Unstage(ctx context.Context, dir string) error {
+	if _, err := Run(ctx, dir, "rev-parse", "--verify", "--quiet", "HEAD"); err != nil {
+		// No commit yet
+		_, err := Run(ctx, dir, "read-tree", "--empty")
+		return err
+	}
+	_, err := Run(ctx, dir, "reset", "--quiet")
+	return err
+}
+
+// CommitStaged commits the staged changes of dir and returns the abbreviated hash
+func CommitStaged(ctx context.Context, dir, message string, author Identity) (string, error) {
+	if _, err := runEnv(ctx, dir, author.env(), "commit", "--quiet", "--message", message); err != nil {
+		return "", err
+	}
+	return Run(ctx, dir, "rev-parse", "--short", "HEAD")
+}
+
+// Push pushes HEAD of dir to branch on remote
+func Push(ctx context.Context, dir, remote, branch string) error {
+	_, err := Run(ctx, dir, "push", "--quiet", remote, "HEAD:refs/heads/"+branch)
+	return err
+}
+
+// CountCommits returns the number of commits reachable from to but not from from
+func CountCommits(ctx context.Context, dir, from, to string) (int, error) {
+	out, err := Run(ctx, dir, "rev-list", "--count", from+".."+to)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(out)
+}
+
+// ConflictError is returned by Merge when the branches conflict; the merge is aborted
+type ConflictError struct {
+	Files []string
+}
+
+// Error lists the conflicting files
+func (e *ConflictError) Error() string {
+	return "merge conflict in " + strings.Join(e.Files, ", ")
+}
+
+// Merge merges branch into the branch checked out in dir with a merge commit
+// and returns a summary of the changes, e.g. "3 files changed, 10 insertions(+)".
+// On conflicts the merge is aborted and a *ConflictError is returned.
+func Merge(ctx context.Context, dir, branch, message string, author Identity) (string, error) {
+	before, err := Run(ctx, dir, "rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := runEnv(ctx, dir, author.env(), "merge", "--no-ff", "--message", message, branch); err != nil {
+		conflicts, _ := Run(ctx, dir, "diff", "--name-only", "--diff-filter=U")
+		if conflicts == "" {
+			return "", err
+		}
+		if _, abortErr := Run(ctx, dir, "merge", "--abort"); abortErr != nil {
+			return "", fmt.Errorf("%w (and aborting the merge failed: %v)", err, abortErr)
+		}
+		return "", &ConflictError{Files: strings.Split(conflicts, "\n")}
+	}
+
+	summary, err := Run(ctx, dir, "diff", "--shortstat", before, "HEAD")
+	return strings.TrimSpace(summary), err
+}
@@ -0,0 +1,139 @@
+package git
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// newRepo creates a repository with one commit and a bare remote named origin
+func newRepo(t *testing.T) (dir, remote string) {
+	t.Helper()
+	ctx := context.Background()
+	dir, remote = t.TempDir(), t.TempDir()
+	if _, err := Run(ctx, remote, "init", "--quiet", "--bare"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Run(ctx, dir, "init", "--quiet"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Run(ctx, dir, "remote", "add", "origin", remote); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, dir, "README.md", "hello\n")
+	if _, err := CommitAll(ctx, dir, "Initial commit", Identity{Name: "Setup", Email: "setup@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	return dir, remote
+}
+
+func writeFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCommitAndPush(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	ctx := context.Background()
+	dir, remote := newRepo(t)
+
+	writeFile(t, dir, "README.md", "hello again\n")
+	writeFile(t, dir, "new.txt", "new\n")
+	if err := StageAll(ctx, dir); err != nil {
+		t.Fatal(err)
+	}
+	if !HasStaged(ctx, dir) {
+		t.Fatal("nothing staged after StageAll")
+	}
+	changes, err := StagedChanges(ctx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 || changes[0] != (Change{Status: 'M', Path: "README.md"}) || changes[1] != (Change{Status: 'A', Path: "new.txt"}) {
+		t.Errorf("staged changes = %+v", changes)
+	}
+
+	author := Identity{Name: "Telecode Bot", Email: "bot@example.com"}
+	hash, err := CommitStaged(ctx, dir, "Update README.md and new.txt", author)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if HasStaged(ctx, dir) {
+		t.Error("changes still staged after the commit")
+	}
+
+	if err := Push(ctx, dir, "origin", "feature"); err != nil {
+		t.Fatal(err)
+	}
+	pushed, err := Run(ctx, remote, "rev-parse", "--short", "refs/heads/feature")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pushed != hash {
+		t.Errorf("remote feature branch = %s, want %s", pushed, hash)
+	}
+
+	got, err := Run(ctx, remote, "log", "-1", "--format=%an <%ae>|%cn <%ce>|%s", "refs/heads/feature")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Telecode Bot <bot@example.com>|Telecode Bot <bot@example.com>|Update README.md and new.txt"
+	if got != want {
+		t.Errorf("pushed commit = %q, want %q", got, want)
+	}
+}
+
+func TestUnstage(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	ctx := context.Background()
+	dir, _ := newRepo(t)
+
+	writeFile(t, dir, "README.md", "changed\n")
+	if err := StageAll(ctx, dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := Unstage(ctx, dir); err != nil {
+		t.Fatal(err)
+	}
+	if HasStaged(ctx, dir) {
+		t.Error("changes still staged after Unstage")
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "README.md")); string(data) != "changed\n" {
+		t.Errorf("README.md = %q after Unstage, want the change kept", data)
+	}
+}
+
+func TestStagedTree(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	ctx := context.Background()
+	dir, _ := newRepo(t)
+
+	writeFile(t, dir, "README.md", "changed\n")
+	if err := StageAll(ctx, dir); err != nil {
+		t.Fatal(err)
+	}
+	before, err := StagedTree(ctx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, dir, "other.txt", "unstaged\n")
+	if tree, _ := StagedTree(ctx, dir); tree != before {
+		t.Errorf("tree changed to %s without staging, want %s", tree, before)
+	}
+	if err := StageAll(ctx, dir); err != nil {
+		t.Fatal(err)
+	}
+	if tree, _ := StagedTree(ctx, dir); tree == before {
+		t.Error("tree unchanged after staging another file")
+	}
+}
@@ -0,0 +1,70 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Commit is a commit as shown by Log and Show
+type Commit struct {
+	Hash    string // Abbreviated
+	Author  string
+	Date    string // Relative, e.g. "2 hours ago"
+	Subject string
+	Body    string
+}
+
+// commitFormat separates the fields of a commit with unit separators
+const commitFormat = "%h%x1f%an%x1f%ar%x1f%s%x1f%b%x1e"
+
+// Log returns the last n commits of the branch checked out in dir
+func Log(ctx context.Context, dir string, n int) ([]Commit, error) {
+	out, err := Run(ctx, dir, "log", fmt.Sprintf("--max-count=%d", n), "--format="+commitFormat)
+	if err != nil {
+		return nil, err
+	}
+	return parseCommits(out), nil
+}
+
+// Show returns a commit and the changes it introduced. rev may be any
+// revision git understands (hash, branch, HEAD~2, ...), but not an option.
+func Show(ctx context.Context, dir, rev string) (Commit, Diff, error) {
+	if strings.HasPrefix(rev, "-") {
+		return Commit{}, Diff{}, fmt.Errorf("invalid revision '%s'", rev)
+	}
+	out, err := Run(ctx, dir, "show", "--no-patch", "--format="+commitFormat, rev, "--")
+	if err != nil {
+		return Commit{}, Diff{}, err
+	}
+	commits := parseCommits(out)
+	if len(commits) == 0 {
+		return Commit{}, Diff{}, fmt.Errorf("'%s' is not a commit", rev)
+	}
+	d, err := DiffCommit(ctx, dir, rev)
+	return commits[0], d, err
+}
+
+// Status returns the short status of dir with the branch and its upstream on the first line
+func Status(ctx context.Context, dir string) (string, error) {
+	return Run(ctx, dir, "status", "--short", "--branch")
+}
+
+// parseCommits parses commits printed with commitFormat
+func parseCommits(out string) []Commit {
+	var commits []Commit
+	for _, record := range strings.Split(out, "\x1e") {
+		fields := strings.Split(strings.TrimLeft(record, "\n"), "\x1f")
+		if len(fields) != 5 {
+			continue
+		}
+		commits = append(commits, Commit{
+			Hash:    fields[0],
+			Author:  fields[1],
+			Date:    fields[2],
+			Subject: fields[3],
+			Body:    strings.TrimSpace(fields[4]),
+		})
+	}
+	return commits
+}
@@ -0,0 +1,206 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// snapshotIdentity commits snapshots without relying on a configured user
+var snapshotIdentity = []string{
+	"GIT_AUTHOR_NAME=telecode", "GIT_AUTHOR_EMAIL=telecode@localhost",
+	"GIT_COMMITTER_NAME=telecode", "GIT_COMMITTER_EMAIL=telecode@localhost",
+}
+
+// Ref is a reference with the commit it points to
+type Ref struct {
+	Name    string
+	Time    time.Time // Commit time
+	Subject string
+}
+
+// Change is a changed file; for restored snapshots 'A' means brought back
+type Change struct {
+	Status byte // 'A' added, 'M' modified or 'D' deleted
+	Path   string
+}
+
+// Snapshot commits the whole working tree of dir, including untracked but
+// not ignored files, and points ref at the commit. HEAD, the index and the
+// files are left untouched.
+func Snapshot(ctx context.Context, dir, ref, message string) error {
+	tree, err := writeWorkingTree(ctx, dir)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"commit-tree", tree, "-m", message}
+	if head, err := Run(ctx, dir, "rev-parse", "--verify", "--quiet", "HEAD"); err == nil {
+		args = append(args, "-p", head)
+	}
+	commit, err := runEnv(ctx, dir, snapshotIdentity, args...)
+	if err != nil {
+		return err
+	}
+	_, err = Run(ctx, dir, "update-ref", ref, commit)
+	return err
+}
+
+// RestoreSnapshot makes the files of dir match the snapshot rev and returns
+// the files it changed. HEAD and the index are left untouched, so changes
+// committed since show up as uncommitted reverts.
+func RestoreSnapshot(ctx context.Context, dir, rev string) ([]Change, error) {
+	// Paths are relative to the top of the work tree, which may be above dir
+	dir, err := Run(ctx, dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	current, err := writeWorkingTree(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	out, err := Run(ctx, dir, "diff-tree", "-r", "--no-renames", "--name-status", current, rev)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	var checkout []string
+	for _, line := range strings.Split(out, "\n") {
+		status, path, ok := strings.Cut(line, "\t")
+		if !ok || status == "" {
+			continue
+		}
+		// Going from the current files to the snapshot: 'D' files were created since
+		c := Change{Status: status[0], Path: path}
+		changes = append(changes, c)
+		if c.Status != 'D' {
+			checkout = append(checkout, path)
+		}
+	}
+	if len(changes) == 0 {
+		return nil, nil
+	}
+
+	if len(checkout) > 0 {
+		index, cleanup, err := tempIndex()
+		if err != nil {
+			return nil, err
+		}
+		defer cleanup()
+		env := []string{"GIT_INDEX_FILE=" + index}
+		if _, err := runEnv(ctx, dir, env, "read-tree", rev); err != nil {
+			return nil, err
+		}
+		args := append([]string{"checkout-index", "--force", "--"}, checkout...)
+		if _, err := runEnv(ctx, dir, env, args...); err != nil {
+			return nil, err
+		}
+	}
+	for _, c := range changes {
+		if c.Status == 'D' {
+			if err := RemoveFile(dir, c.Path); err != nil {
+				return changes, err
+			}
+		}
+	}
+	return changes, nil
+}
+
+// RemoveFile deletes the file at path, relative to root, and then the parent
+// directories it leaves empty, up to root
+func RemoveFile(root, path string) error {
+	if err := os.Remove(filepath.Join(root, path)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for dir := filepath.Dir(path); dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+		// Fails on the first directory that is not empty
+		if os.Remove(filepath.Join(root, dir)) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// ListRefs returns the references under prefix (e.g. "refs/telecode/"), newest commit first
+func ListRefs(ctx context.Context, dir, prefix string) ([]Ref, error) {
+	out, err := Run(ctx, dir, "for-each-ref", "--sort=-committerdate",
+		"--format=%(refname)%1f%(committerdate:unix)%1f%(subject)", prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []Ref
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.SplitN(line, "\x1f", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		unix, _ := strconv.ParseInt(fields[1], 10, 64)
+		refs = append(refs, Ref{Name: fields[0], Time: time.Unix(unix, 0), Subject: fields[2]})
+	}
+	return refs, nil
+}
+
+// DeleteRef deletes a reference
+func DeleteRef(ctx context.Context, dir, ref string) error {
+	_, err := Run(ctx, dir, "update-ref", "-d", ref)
+	return err
+}
+
+// writeWorkingTree stores the working tree of dir as a tree object using a
+// temporary index and returns its ID
+func writeWorkingTree(ctx context.Context, dir string) (string, error) {
+	index, cleanup, err := tempIndex()
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+	env := []string{"GIT_INDEX_FILE=" + index}
+
+	if err := seedIndex(ctx, dir, index, env); err != nil {
+		return "", err
+	}
+	if _, err := runEnv(ctx, dir, env, "add", "--all"); err != nil {
+		return "", err
+	}
+	return runEnv(ctx, dir, env, "write-tree")
+}
+
+// seedIndex fills the temporary index with a copy of the real one, like
+// git stash create, so tracked files matching .gitignore are kept and add
+// only re-hashes files whose stat data changed. Without a real index it
+// starts from HEAD.
+func seedIndex(ctx context.Context, dir, index string, env []string) error {
+	real, err := Run(ctx, dir, "rev-parse", "--git-path", "index")
+	if err != nil {
+		return err
+	}
+	if !filepath.IsAbs(real) {
+		real = filepath.Join(dir, real)
+	}
+	if data, err := os.ReadFile(real); err == nil {
+		return os.WriteFile(index, data, 0600)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if _, err := Run(ctx, dir, "rev-parse", "--verify", "--quiet", "HEAD"); err != nil {
+		return nil
+	}
+	_, err = runEnv(ctx, dir, env, "read-tree", "HEAD")
+	return err
+}
+
+// tempIndex returns the path of a temporary index file and a function removing it
+func tempIndex() (string, func(), error) {
+	dir, err := os.MkdirTemp("", "telecode-index-")
+	if err != nil {
+		return "", nil, err
+	}
+	return filepath.Join(dir, "index"), func() { os.RemoveAll(dir) }, nil
+}
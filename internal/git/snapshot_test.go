@@ -0,0 +1,111 @@
+package git
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	ctx := context.Background()
+	dir, _ := newRepo(t)
+	writeFile(t, dir, ".gitignore", "*.log\n")
+	writeFile(t, dir, "tracked.txt", "tracked\n")
+	if _, err := CommitAll(ctx, dir, "Add files", Identity{Name: "Setup", Email: "setup@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Uncommitted, untracked and ignored files at the time of the snapshot
+	writeFile(t, dir, "README.md", "edited\n")
+	writeFile(t, dir, "untracked.txt", "untracked\n")
+	writeFile(t, dir, "debug.log", "before\n")
+	const ref = "refs/telecode/test"
+	if err := Snapshot(ctx, dir, ref, "snapshot"); err != nil {
+		t.Fatal(err)
+	}
+	head, _ := Run(ctx, dir, "rev-parse", "HEAD")
+
+	writeFile(t, dir, "README.md", "edited again\n")
+	writeFile(t, dir, "debug.log", "after\n")
+	if err := os.Remove(filepath.Join(dir, "tracked.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "untracked.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "new", "deep"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, dir, "new/deep/file.txt", "created\n")
+
+	changes, err := RestoreSnapshot(ctx, dir, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Change{
+		{Status: 'M', Path: "README.md"},
+		{Status: 'D', Path: "new/deep/file.txt"},
+		{Status: 'A', Path: "tracked.txt"},
+		{Status: 'A', Path: "untracked.txt"},
+	}
+	if !slices.Equal(changes, want) {
+		t.Errorf("changes = %+v, want %+v", changes, want)
+	}
+
+	for name, content := range map[string]string{
+		"README.md":     "edited\n",
+		"tracked.txt":   "tracked\n",
+		"untracked.txt": "untracked\n",
+		"debug.log":     "after\n", // Ignored files are left alone
+	} {
+		if data, err := os.ReadFile(filepath.Join(dir, name)); err != nil || string(data) != content {
+			t.Errorf("%s = %q (%v), want %q", name, data, err, content)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "new")); !os.IsNotExist(err) {
+		t.Errorf("directory of the deleted file still exists: %v", err)
+	}
+	if now, _ := Run(ctx, dir, "rev-parse", "HEAD"); now != head {
+		t.Errorf("HEAD moved from %s to %s", head, now)
+	}
+	if HasStaged(ctx, dir) {
+		t.Error("restore staged changes")
+	}
+
+	// Restoring again changes nothing
+	if changes, err := RestoreSnapshot(ctx, dir, ref); err != nil || len(changes) != 0 {
+		t.Errorf("second restore = %+v, %v, want no changes", changes, err)
+	}
+}
+
+func TestRemoveFile(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"a/b/c/file.txt", "a/keep.txt"} {
+		if err := os.MkdirAll(filepath.Join(root, filepath.Dir(name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, root, name, "x")
+	}
+
+	if err := RemoveFile(root, "a/b/c/file.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "a", "b")); !os.IsNotExist(err) {
+		t.Errorf("empty directory a/b still exists: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "a", "keep.txt")); err != nil {
+		t.Errorf("a/keep.txt removed: %v", err)
+	}
+	if err := RemoveFile(root, "missing.txt"); err != nil {
+		t.Errorf("RemoveFile of a missing file = %v", err)
+	}
+	if _, err := os.Stat(root); err != nil {
+		t.Errorf("root removed: %v", err)
+	}
+}
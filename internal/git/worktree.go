@@ -0,0 +1,65 @@
+package git
+
+import (
+	"context"
+	"strings"
+)
+
+// Worktree is a working tree attached to a repository
+type Worktree struct {
+	Path   string
+	Branch string // Short branch name, empty when detached
+	Head   string // Commit checked out
+}
+
+// ListWorktrees returns the working trees of the repository at dir, the main one first
+func ListWorktrees(ctx context.Context, dir string) ([]Worktree, error) {
+	out, err := Run(ctx, dir, "worktree", "list", "--porcelain")
+	if err != nil {
+		return nil, err
+	}
+
+	var worktrees []Worktree
+	for _, block := range strings.Split(out, "\n\n") {
+		var w Worktree
+		for _, line := range strings.Split(block, "\n") {
+			field, value, _ := strings.Cut(line, " ")
+			switch field {
+			case "worktree":
+				w.Path = value
+			case "HEAD":
+				w.Head = value
+			case "branch":
+				w.Branch = strings.TrimPrefix(value, "refs/heads/")
+			}
+		}
+		if w.Path != "" {
+			worktrees = append(worktrees, w)
+		}
+	}
+	return worktrees, nil
+}
+
+// AddWorktree checks out branch at path, creating the branch from the
+// current HEAD of dir if it does not exist yet
+func AddWorktree(ctx context.Context, dir, path, branch string) error {
+	// Forget worktrees whose directory was deleted by hand, they would block the branch
+	if _, err := Run(ctx, dir, "worktree", "prune"); err != nil {
+		return err
+	}
+
+	args := []string{"worktree", "add", "--quiet"}
+	if BranchExists(ctx, dir, branch) {
+		args = append(args, path, branch)
+	} else {
+		args = append(args, "-b", branch, path)
+	}
+	_, err := Run(ctx, dir, args...)
+	return err
+}
+
+// RemoveWorktree deletes the working tree at path, discarding its changes
+func RemoveWorktree(ctx context.Context, dir, path string) error {
+	_, err := Run(ctx, dir, "worktree", "remove", "--force", path)
+	return err
+}
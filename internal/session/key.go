@@ -0,0 +1,44 @@
+package session
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Key identifies a conversation: a chat, or a forum topic within a chat
+type Key struct {
+	ChatID   int64
+	ThreadID int // Forum topic; 0 outside forum topics
+}
+
+// String returns "chatID" or "chatID:threadID"
+func (k Key) String() string {
+	if k.ThreadID == 0 {
+		return strconv.FormatInt(k.ChatID, 10)
+	}
+	return fmt.Sprintf("%d:%d", k.ChatID, k.ThreadID)
+}
+
+// MarshalText encodes the key as a JSON object key
+func (k Key) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// UnmarshalText decodes a key written by MarshalText
+func (k *Key) UnmarshalText(text []byte) error {
+	chat, thread, hasThread := strings.Cut(string(text), ":")
+
+	chatID, err := strconv.ParseInt(chat, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid chat ID in session key '%s'", text)
+	}
+	k.ChatID, k.ThreadID = chatID, 0
+
+	if hasThread {
+		if k.ThreadID, err = strconv.Atoi(thread); err != nil {
+			return fmt.Errorf("invalid topic ID in session key '%s'", text)
+		}
+	}
+	return nil
+}
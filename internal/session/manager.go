@@ -1,47 +1,323 @@
 package session
 
 import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
-// Manager manages session IDs per chat_id
+const (
+	// maxSessionsPerChat limits how many named sessions a chat keeps;
+	// the least recently used ones are forgotten first
+	maxSessionsPerChat = 20
+
+	// promptPreviewLength is the number of runes kept from the last prompt
+	promptPreviewLength = 80
+)
+
+// validName matches session names usable in /switch and callback data
+var validName = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,32}$`)
+
+// ValidName reports whether name can name a session. Names also end up in
+// git branch and ref names, which cannot start with '.', contain ".." or
+// end with ".lock".
+func ValidName(name string) bool {
+	return validName.MatchString(name) &&
+		!strings.HasPrefix(name, ".") &&
+		!strings.Contains(name, "..") &&
+		!strings.HasSuffix(name, ".lock")
+}
+
+// Session is a named agent conversation of a chat
+type Session struct {
+	Name       string    `json:"name"`
+	ID         string    `json:"id,omitempty"` // CLI session ID, empty until the first run
+	CLI        string    `json:"cli"`
+	CreatedAt  time.Time `json:"created_at"`
+	UsedAt     time.Time `json:"used_at"`
+	LastPrompt string    `json:"last_prompt,omitempty"`
+}
+
+// ChatSessions holds the named sessions of a chat
+type ChatSessions struct {
+	Active   string     `json:"active,omitempty"`
+	Sessions []*Session `json:"sessions"`
+}
+
+// find returns the session with the given name
+func (c *ChatSessions) find(name string) *Session {
+	for _, s := range c.Sessions {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// active returns the active session, or nil if the next run starts a fresh one
+func (c *ChatSessions) active() *Session {
+	return c.find(c.Active)
+}
+
+// nextName returns an unused automatic session name
+func (c *ChatSessions) nextName() string {
+	for i := len(c.Sessions) + 1; ; i++ {
+		name := fmt.Sprintf("s%d", i)
+		if c.find(name) == nil {
+			return name
+		}
+	}
+}
+
+// add appends a session, makes it active and forgets the oldest sessions over the limit
+func (c *ChatSessions) add(s *Session) {
+	c.dropUnused()
+	c.Sessions = append(c.Sessions, s)
+	c.Active = s.Name
+
+	if len(c.Sessions) > maxSessionsPerChat {
+		sort.SliceStable(c.Sessions, func(i, j int) bool {
+			return c.Sessions[i].UsedAt.After(c.Sessions[j].UsedAt)
+		})
+		c.Sessions = c.Sessions[:maxSessionsPerChat]
+	}
+}
+
+// dropUnused removes the active session if it was never run
+func (c *ChatSessions) dropUnused() {
+	for i, s := range c.Sessions {
+		if s.Name == c.Active && s.ID == "" {
+			c.Sessions = append(c.Sessions[:i], c.Sessions[i+1:]...)
+			break
+		}
+	}
+	c.Active = ""
+}
+
+// Manager manages sessions and settings per conversation
 type Manager struct {
-	sessions map[int64]string
+	chats    map[Key]*ChatSessions
+	settings map[Key]ChatSettings
+	store    Store
 	mu       sync.RWMutex
 }
 
-// NewManager creates a new session manager
-func NewManager() *Manager {
-	return &Manager{
-		sessions: make(map[int64]string),
+// NewManager creates a new session manager and loads saved state from store.
+// A nil store keeps everything in memory.
+func NewManager(store Store) (*Manager, error) {
+	m := &Manager{
+		chats:    make(map[Key]*ChatSessions),
+		settings: make(map[Key]ChatSettings),
+		store:    store,
+	}
+
+	if store != nil {
+		state, err := store.Load()
+		if err != nil {
+			return nil, err
+		}
+		for key, chat := range state.Chats {
+			m.chats[key] = chat
+		}
+		for key, settings := range state.Settings {
+			m.settings[key] = settings
+		}
+	}
+
+	return m, nil
+}
+
+// chat returns the sessions of a chat, creating the entry if needed. Caller must hold m.mu.
+func (m *Manager) chat(key Key) *ChatSessions {
+	c, ok := m.chats[key]
+	if !ok {
+		c = &ChatSessions{}
+		m.chats[key] = c
 	}
+	return c
 }
 
-// Get returns the session ID for a chat_id
-func (m *Manager) Get(chatID int64) string {
+// Get returns the active session ID for a conversation
+func (m *Manager) Get(key Key) string {
+	active, _ := m.Active(key)
+	return active.ID
+}
+
+// Active returns the active session for a conversation
+func (m *Manager) Active(key Key) (Session, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	c, ok := m.chats[key]
+	if !ok {
+		return Session{}, false
+	}
+	if s := c.active(); s != nil {
+		return *s, true
+	}
+	return Session{}, false
+}
+
+// List returns the sessions of a chat (most recently used first) and the active name
+func (m *Manager) List(key Key) ([]Session, string) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	return m.sessions[chatID]
+	c, ok := m.chats[key]
+	if !ok {
+		return nil, ""
+	}
+	sessions := make([]Session, 0, len(c.Sessions))
+	for _, s := range c.Sessions {
+		sessions = append(sessions, *s)
+	}
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].UsedAt.After(sessions[j].UsedAt)
+	})
+	return sessions, c.Active
 }
 
-// Set saves the session ID for a chat_id
-func (m *Manager) Set(chatID int64, sessionID string) {
+// Exists checks if the chat has an active session with a CLI session ID
+func (m *Manager) Exists(key Key) bool {
+	return m.Get(key) != ""
+}
+
+// Update records a run on the active session, creating one if the chat has none.
+// An empty sessionID keeps the current ID.
+func (m *Manager) Update(key Key, cli, sessionID, prompt string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := m.chat(key)
+	s := c.active()
+	if s == nil {
+		if sessionID == "" {
+			return
+		}
+		now := time.Now()
+		s = &Session{Name: c.nextName(), CLI: cli, CreatedAt: now, UsedAt: now}
+		c.add(s)
+	}
+
+	if sessionID != "" {
+		s.ID = sessionID
+	}
+	s.UsedAt = time.Now()
+	if runes := []rune(prompt); len(runes) > promptPreviewLength {
+		prompt = string(runes[:promptPreviewLength]) + "…"
+	}
+	s.LastPrompt = prompt
+	m.flush()
+}
+
+// New creates an empty session and makes it active; the CLI session ID
+// is filled in by the first run. An empty name picks one automatically.
+func (m *Manager) New(key Key, name, cli string) (Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := m.chat(key)
+	c.dropUnused()
+	if name == "" {
+		name = c.nextName()
+	}
+	if !ValidName(name) {
+		return Session{}, fmt.Errorf("invalid session name '%s' (use up to 32 letters, digits, '.', '_' or '-', not starting with '.' or ending with '.lock')", name)
+	}
+	if c.find(name) != nil {
+		return Session{}, fmt.Errorf("session '%s' already exists", name)
+	}
+
+	now := time.Now()
+	s := &Session{Name: name, CLI: cli, CreatedAt: now, UsedAt: now}
+	c.add(s)
+	m.flush()
+	return *s, nil
+}
+
+// Switch makes the named session active
+func (m *Manager) Switch(key Key, name string) (Session, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.sessions[chatID] = sessionID
+
+	c := m.chat(key)
+	s := c.find(name)
+	if s == nil {
+		return Session{}, fmt.Errorf("session '%s' not found", name)
+	}
+	if c.Active != name {
+		c.dropUnused()
+		c.Active = name
+	}
+	s.UsedAt = time.Now()
+	m.flush()
+	return *s, nil
+}
+
+// Attach makes an existing CLI session (e.g. started on the desktop) active,
+// reusing the chat's session entry if the ID is already known
+func (m *Manager) Attach(key Key, sessionID, cli string) Session {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := m.chat(key)
+	now := time.Now()
+	for _, s := range c.Sessions {
+		if s.ID == sessionID && s.CLI == cli {
+			if c.Active != s.Name {
+				c.dropUnused()
+				c.Active = s.Name
+			}
+			s.UsedAt = now
+			m.flush()
+			return *s
+		}
+	}
+
+	s := &Session{Name: c.nextName(), ID: sessionID, CLI: cli, CreatedAt: now, UsedAt: now}
+	c.add(s)
+	m.flush()
+	return *s
 }
 
-// Delete removes the session for a chat_id
-func (m *Manager) Delete(chatID int64) {
+// Reset deactivates the current session so the next run starts a fresh one
+func (m *Manager) Reset(key Key) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	delete(m.sessions, chatID)
+	c, ok := m.chats[key]
+	if !ok {
+		return
+	}
+	c.dropUnused()
+	m.flush()
 }
 
-// Exists checks if a session exists
-func (m *Manager) Exists(chatID int64) bool {
+// GetSettings returns the settings for a conversation
+func (m *Manager) GetSettings(key Key) ChatSettings {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	_, exists := m.sessions[chatID]
-	return exists
+	return m.settings[key]
+}
+
+// SetSettings saves the settings for a conversation
+func (m *Manager) SetSettings(key Key, settings ChatSettings) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.settings[key] = settings
+	m.flush()
+}
+
+// flush writes the current state to the store. Caller must hold m.mu.
+func (m *Manager) flush() {
+	if m.store == nil {
+		return
+	}
+	state := &State{
+		Chats:    m.chats,
+		Settings: m.settings,
+	}
+	if err := m.store.Save(state); err != nil {
+		fmt.Printf("❌ Failed to persist sessions: %v\n", err)
+	}
 }
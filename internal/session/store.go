@@ -0,0 +1,94 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ChatSettings stores per-conversation configuration
+type ChatSettings struct {
+	CLI string `json:"cli,omitempty"`
+
+	// Model overrides the default model of the chat's CLI
+	Model string `json:"model,omitempty"`
+}
+
+// State is the persisted session state of a workspace
+type State struct {
+	Chats    map[Key]*ChatSessions `json:"chats"`
+	Settings map[Key]ChatSettings  `json:"settings"`
+}
+
+// Store persists session state
+type Store interface {
+	// Load returns the saved state (empty if nothing was saved yet)
+	Load() (*State, error)
+
+	// Save replaces the saved state
+	Save(state *State) error
+}
+
+// FileStore stores state as a JSON file
+type FileStore struct {
+	path string
+}
+
+// NewFileStore creates a store backed by the JSON file at path
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+// Load reads the state from the JSON file
+func (s *FileStore) Load() (*State, error) {
+	state := &State{}
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session store: %w", err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to parse session store %s: %w", s.path, err)
+	}
+	return state, nil
+}
+
+// Save writes the state atomically (temp file + rename)
+func (s *FileStore) Save(state *State) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode session store: %w", err)
+	}
+
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create session store directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // No-op after a successful rename
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write session store: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync session store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close session store: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to replace session store: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+package usage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// retention is how long daily usage is kept
+const retention = 400 * 24 * time.Hour
+
+// dayFormat is the layout of Entry.Day
+const dayFormat = "2006-01-02"
+
+// Totals is accumulated usage
+type Totals struct {
+	Runs             int     `json:"runs"`
+	InputTokens      int64   `json:"input_tokens"`
+	OutputTokens     int64   `json:"output_tokens"`
+	CacheReadTokens  int64   `json:"cache_read_tokens,omitempty"`
+	CacheWriteTokens int64   `json:"cache_write_tokens,omitempty"`
+	CostUSD          float64 `json:"cost_usd"`
+	DurationMS       int64   `json:"duration_ms"`
+}
+
+// Add adds other to t
+func (t *Totals) Add(other Totals) {
+	t.Runs += other.Runs
+	t.InputTokens += other.InputTokens
+	t.OutputTokens += other.OutputTokens
+	t.CacheReadTokens += other.CacheReadTokens
+	t.CacheWriteTokens += other.CacheWriteTokens
+	t.CostUSD += other.CostUSD
+	t.DurationMS += other.DurationMS
+}
+
+// Tokens returns all tokens used, including cached ones
+func (t Totals) Tokens() int64 {
+	return t.InputTokens + t.OutputTokens + t.CacheReadTokens + t.CacheWriteTokens
+}
+
+// Entry is the usage of one day by one user in one chat of a workspace with one CLI
+type Entry struct {
+	Day       string `json:"day"` // Local date, YYYY-MM-DD
+	Workspace string `json:"workspace"`
+	ChatID    int64  `json:"chat_id"`
+	UserID    int64  `json:"user_id"`
+	CLI       string `json:"cli"`
+	Totals
+}
+
+// Run is the usage of a single agent run
+type Run struct {
+	Time      time.Time
+	Workspace string
+	ChatID    int64
+	UserID    int64
+	UserName  string
+	CLI       string
+	Usage     Totals
+}
+
+// ledgerFile is the persisted ledger
+type ledgerFile struct {
+	Entries []Entry          `json:"entries"`
+	Users   map[int64]string `json:"users,omitempty"` // Last known name of each user
+
+	// Overrides is the day on which the budget of a workspace was lifted
+	Overrides map[string]string `json:"overrides,omitempty"`
+}
+
+// Ledger accumulates usage per day, workspace, chat, user and CLI.
+// It is saved as a JSON file after every run; with an empty path it is in-memory only.
+type Ledger struct {
+	path  string
+	data  ledgerFile
+	index map[Entry]int // Entry with zero Totals -> position in data.Entries
+	mu    sync.RWMutex
+}
+
+// NewLedger loads the ledger stored at path
+func NewLedger(path string) (*Ledger, error) {
+	l := &Ledger{path: path, index: make(map[Entry]int)}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read usage ledger: %w", err)
+		}
+		if err == nil {
+			if err := json.Unmarshal(data, &l.data); err != nil {
+				return nil, fmt.Errorf("failed to parse usage ledger %s: %w", path, err)
+			}
+		}
+	}
+	if l.data.Users == nil {
+		l.data.Users = make(map[int64]string)
+	}
+	if l.data.Overrides == nil {
+		l.data.Overrides = make(map[string]string)
+	}
+	l.reindex()
+	return l, nil
+}
+
+// Add records a run
+func (l *Ledger) Add(run Run) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	run.Usage.Runs = 1
+	key := Entry{
+		Day:       run.Time.Local().Format(dayFormat),
+		Workspace: run.Workspace,
+		ChatID:    run.ChatID,
+		UserID:    run.UserID,
+		CLI:       run.CLI,
+	}
+	if i, ok := l.index[key]; ok {
+		l.data.Entries[i].Add(run.Usage)
+	} else {
+		entry := key
+		entry.Totals = run.Usage
+		l.index[key] = len(l.data.Entries)
+		l.data.Entries = append(l.data.Entries, entry)
+	}
+	if run.UserName != "" {
+		l.data.Users[run.UserID] = run.UserName
+	}
+
+	l.prune(run.Time)
+	l.flush()
+}
+
+// Entries returns the entries matching filter from day since onwards
+func (l *Ledger) Entries(since time.Time, filter func(Entry) bool) []Entry {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	from := since.Local().Format(dayFormat)
+	var entries []Entry
+	for _, e := range l.data.Entries {
+		if e.Day >= from && (filter == nil || filter(e)) {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// Sum returns the totals of the entries matching filter from day since onwards
+func (l *Ledger) Sum(since time.Time, filter func(Entry) bool) Totals {
+	var t Totals
+	for _, e := range l.Entries(since, filter) {
+		t.Add(e.Totals)
+	}
+	return t
+}
+
+// UserName returns the last known name of a user, or "" if there is none
+func (l *Ledger) UserName(userID int64) string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.data.Users[userID]
+}
+
+// Override lifts the budget of a workspace for the day of now
+func (l *Ledger) Override(workspace string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.data.Overrides[workspace] = now.Local().Format(dayFormat)
+	l.flush()
+}
+
+// Overridden reports whether the budget of a workspace is lifted on the day of now
+func (l *Ledger) Overridden(workspace string, now time.Time) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.data.Overrides[workspace] == now.Local().Format(dayFormat)
+}
+
+// prune drops entries older than retention. Caller must hold l.mu.
+func (l *Ledger) prune(now time.Time) {
+	oldest := now.Add(-retention).Local().Format(dayFormat)
+	if len(l.data.Entries) == 0 || l.data.Entries[0].Day >= oldest {
+		return
+	}
+	kept := l.data.Entries[:0]
+	for _, e := range l.data.Entries {
+		if e.Day >= oldest {
+			kept = append(kept, e)
+		}
+	}
+	l.data.Entries = kept
+	l.reindex()
+}
+
+// reindex rebuilds the entry index. Caller must hold l.mu.
+func (l *Ledger) reindex() {
+	clear(l.index)
+	for i, e := range l.data.Entries {
+		e.Totals = Totals{}
+		l.index[e] = i
+	}
+}
+
+// flush saves the ledger, logging failures. Caller must hold l.mu.
+func (l *Ledger) flush() {
+	if l.path == "" {
+		return
+	}
+	if err := l.save(); err != nil {
+		fmt.Printf("❌ Failed to save usage ledger: %v\n", err)
+	}
+}
+
+// save writes the ledger atomically (temp file + rename)
+func (l *Ledger) save() error {
+	data, err := json.MarshalIndent(l.data, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(l.path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(l.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op after a successful rename
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), l.path)
+}
+
+// StartOfDay returns the local midnight of t
+func StartOfDay(t time.Time) time.Time {
+	t = t.Local()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+}
+
+// StartOfWeek returns the local midnight of the Monday of t's week
+func StartOfWeek(t time.Time) time.Time {
+	day := StartOfDay(t)
+	return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+}
+
+// StartOfMonth returns the local midnight of the first day of t's month
+func StartOfMonth(t time.Time) time.Time {
+	t = t.Local()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.Local)
+}
@@ -0,0 +1,87 @@
+package usage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLedgerAddAndSum(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	l, err := NewLedger(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2026, 3, 18, 15, 0, 0, 0, time.Local) // A Wednesday
+	runs := []Run{
+		{Time: now, Workspace: "a", ChatID: 1, UserID: 10, UserName: "ann", CLI: "claude", Usage: Totals{InputTokens: 100, OutputTokens: 10, CostUSD: 0.5}},
+		{Time: now.Add(time.Hour), Workspace: "a", ChatID: 1, UserID: 10, CLI: "claude", Usage: Totals{InputTokens: 50, OutputTokens: 5, CostUSD: 0.25}},
+		{Time: now.AddDate(0, 0, -2), Workspace: "a", ChatID: 2, UserID: 11, UserName: "bob", CLI: "codex", Usage: Totals{InputTokens: 7, CacheReadTokens: 3}},
+		{Time: now, Workspace: "b", ChatID: 3, UserID: 10, CLI: "claude", Usage: Totals{OutputTokens: 1}},
+		{Time: now.AddDate(0, -2, 0), Workspace: "a", ChatID: 1, UserID: 10, CLI: "claude", Usage: Totals{InputTokens: 1000}},
+	}
+	for _, run := range runs {
+		l.Add(run)
+	}
+
+	workspace := func(name string) func(Entry) bool {
+		return func(e Entry) bool { return e.Workspace == name }
+	}
+	tests := []struct {
+		name   string
+		since  time.Time
+		filter func(Entry) bool
+		want   Totals
+	}{
+		{"today in a", StartOfDay(now), workspace("a"), Totals{Runs: 2, InputTokens: 150, OutputTokens: 15, CostUSD: 0.75}},
+		{"this week in a", StartOfWeek(now), workspace("a"), Totals{Runs: 3, InputTokens: 157, OutputTokens: 15, CacheReadTokens: 3, CostUSD: 0.75}},
+		{"today everywhere", StartOfDay(now), nil, Totals{Runs: 3, InputTokens: 150, OutputTokens: 16, CostUSD: 0.75}},
+		{"this month for bob", StartOfMonth(now), func(e Entry) bool { return e.UserID == 11 }, Totals{Runs: 1, InputTokens: 7, CacheReadTokens: 3}},
+	}
+	reloaded, err := NewLedger(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, ledger := range map[string]*Ledger{"in memory": l, "reloaded": reloaded} {
+				if got := ledger.Sum(tt.since, tt.filter); got != tt.want {
+					t.Errorf("%s: Sum() = %+v, want %+v", name, got, tt.want)
+				}
+			}
+		})
+	}
+
+	// Runs of the same day, chat, user and CLI share an entry
+	if entries := l.Entries(StartOfDay(now), workspace("a")); len(entries) != 1 {
+		t.Errorf("Entries() = %+v, want one entry", entries)
+	}
+	if name := reloaded.UserName(10); name != "ann" {
+		t.Errorf("UserName(10) = %q, want ann", name)
+	}
+}
+
+func TestLedgerOverride(t *testing.T) {
+	l, err := NewLedger("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 3, 18, 23, 0, 0, 0, time.Local)
+	l.Override("a", now)
+	if !l.Overridden("a", now) || l.Overridden("b", now) {
+		t.Error("override does not apply to exactly workspace a")
+	}
+	if l.Overridden("a", now.Add(2*time.Hour)) {
+		t.Error("override still applies the next day")
+	}
+}
+
+func TestStartOfWeek(t *testing.T) {
+	for _, day := range []int{16, 18, 22} { // Monday, Wednesday, Sunday
+		got := StartOfWeek(time.Date(2026, 3, day, 12, 0, 0, 0, time.Local))
+		if want := time.Date(2026, 3, 16, 0, 0, 0, 0, time.Local); !got.Equal(want) {
+			t.Errorf("StartOfWeek(March %d) = %v, want %v", day, got, want)
+		}
+	}
+}